@@ -1,32 +1,84 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/viper"
 
 	"github.com/lukasdietrich/proxyproxy/internal/auto"
+	"github.com/lukasdietrich/proxyproxy/internal/config"
 	"github.com/lukasdietrich/proxyproxy/internal/proxy"
 	"github.com/lukasdietrich/proxyproxy/internal/server"
 )
 
 func init() {
-	viper.SetDefault("verbose", false)
+	config.Define("verbose", config.Bool, false)
 }
 
 func main() {
-	setupConfig()
+	configPath := flag.String("config", "", "path to a yaml or toml config file")
+	flag.Parse()
+
+	if err := config.Setup(*configPath); err != nil {
+		fatal(err)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if err := command(args); err != nil {
+			fatal(err)
+		}
+
+		return
+	}
+
+	if err := config.Validate(); err != nil {
+		fatal(err)
+	}
 
 	if err := run(); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal reports every joined error separately, so that all invalid settings are visible at once.
+func fatal(err error) {
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	for _, err := range errs {
 		slog.Error("fatal", slog.Any("err", err))
 	}
+
+	os.Exit(1)
+}
+
+func command(args []string) error {
+	switch strings.Join(args, " ") {
+	case "config print":
+		return config.Print(os.Stdout)
+
+	case "config validate":
+		return config.Validate()
+
+	default:
+		return fmt.Errorf("unknown command %q, expected \"config print\" or \"config validate\"", strings.Join(args, " "))
+	}
 }
 
 func run() error {
-	if viper.GetBool("verbose") {
-		slog.SetLogLoggerLevel(slog.LevelDebug)
-		slog.Debug("enabling debug logging")
+	if err := setLogLevel(); err != nil {
+		return err
 	}
 
 	if err := auto.ConfigureFromEnv(); err != nil {
@@ -38,15 +90,79 @@ func run() error {
 		return err
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	listener := server.FromEnv(handler)
+	servers := []*http.Server{listener}
+
+	if admin := server.AdminFromEnv(handler.Admin()); admin != nil {
+		servers = append(servers, admin)
+	}
 
-	slog.Info("starting http server", slog.String("addr", listener.Addr))
-	return listener.ListenAndServe()
+	grace := viper.GetDuration("http.timeout.shutdown")
+
+	// viper must not be read once the config file is watched, except by the reloaders
+	config.Watch(append(handler.Reloaders(), config.Reloader{
+		Keys:  []string{"verbose"},
+		Apply: setLogLevel,
+	})...)
+
+	errs := make(chan error, len(servers))
+
+	for _, srv := range servers {
+		slog.Info("starting http server", slog.String("addr", srv.Addr))
+		go func() { errs <- srv.ListenAndServe() }()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+
+	case <-ctx.Done():
+	}
+
+	// a second signal terminates immediately
+	stop()
+
+	return shutdown(handler, servers, grace)
 }
 
-func setupConfig() {
-	viper.SetTypeByDefaultValue(true)
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.SetEnvPrefix("PROXYPROXY")
+// setLogLevel applies the verbose setting, also when it is changed in the config file.
+func setLogLevel() error {
+	level := slog.LevelInfo
+	if viper.GetBool("verbose") {
+		level = slog.LevelDebug
+	}
+
+	slog.SetLogLoggerLevel(level)
+	slog.Debug("enabling debug logging")
+
+	return nil
+}
+
+// shutdown stops accepting connections and waits for the open ones, including tunnels, until the
+// grace period is over. The remaining connections are closed forcibly.
+func shutdown(handler *proxy.Handler, servers []*http.Server, grace time.Duration) error {
+	slog.Info("shutting down, waiting for open connections", slog.Duration("grace", grace))
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Warn("closing remaining connections forcibly", slog.String("addr", srv.Addr), slog.Any("err", err))
+
+			if err := srv.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := handler.Shutdown(ctx); err != nil {
+		slog.Warn("closed remaining tunnels forcibly", slog.Any("err", err))
+	}
+
+	slog.Info("shutdown complete")
+	return nil
 }
@@ -0,0 +1,132 @@
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("accesslog.path", config.String, "")
+	config.Define("accesslog.format", config.String, formatJSON)
+	config.Define("accesslog.rotate.size", config.Size, "100mb")
+	config.Define("accesslog.rotate.backups", config.Int, 5)
+	config.AddValidator(func() error {
+		_, err := formatFromEnv()
+		return err
+	})
+}
+
+const (
+	// pathStdout writes the access log to stdout instead of a file.
+	pathStdout = "-"
+
+	megabyte = 1024 * 1024
+)
+
+// Entry describes a completed request or tunnel.
+type Entry struct {
+	Time   time.Time
+	ID     string
+	Client string
+	User   string
+	Method string
+	Target string
+	Proto  string
+	// Upstream is DIRECT or the url of the upstream proxy. It is empty, if the request was not
+	// forwarded.
+	Upstream string
+	Status   int
+	// BytesIn are sent to the client and BytesOut are sent to the target.
+	BytesIn   int64
+	BytesOut  int64
+	Duration  time.Duration
+	Referer   string
+	UserAgent string
+}
+
+// Logger writes entries in one of the supported formats.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	format formatFunc
+	buf    []byte
+}
+
+// FromEnv returns nil, if the access log is disabled.
+func FromEnv() (*Logger, error) {
+	path := viper.GetString("accesslog.path")
+	if path == "" {
+		return nil, nil
+	}
+
+	format, err := formatFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	if path == pathStdout {
+		return newLogger(nopCloser{os.Stdout}, format), nil
+	}
+
+	slog.Info("writing access log", slog.String("path", path))
+
+	return newLogger(&lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    max(1, int(viper.GetSizeInBytes("accesslog.rotate.size")/megabyte)),
+		MaxBackups: viper.GetInt("accesslog.rotate.backups"),
+	}, format), nil
+}
+
+func formatFromEnv() (formatFunc, error) {
+	format, ok := formats[strings.ToLower(viper.GetString("accesslog.format"))]
+	if !ok {
+		return nil, fmt.Errorf("invalid accesslog.format: must be one of %s", strings.Join(formatNames(), ", "))
+	}
+
+	return format, nil
+}
+
+func newLogger(w io.WriteCloser, format formatFunc) *Logger {
+	return &Logger{
+		w:      w,
+		format: format,
+	}
+}
+
+// Log writes the entry. Errors are reported, but do not affect the request.
+func (l *Logger) Log(e *Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.format(l.buf[:0], e), '\n')
+
+	if _, err := l.w.Write(l.buf); err != nil {
+		slog.Warn("could not write access log", slog.Any("err", err))
+	}
+}
+
+// Close closes the underlying file.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.w.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
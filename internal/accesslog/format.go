@@ -0,0 +1,190 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatJSON     = "json"
+	formatLogfmt   = "logfmt"
+	formatSquid    = "squid"
+	formatCombined = "combined"
+)
+
+// formatFunc appends the entry to the buffer without a trailing newline.
+type formatFunc func([]byte, *Entry) []byte
+
+var (
+	formats = map[string]formatFunc{
+		formatJSON:     appendJSON,
+		formatLogfmt:   appendLogfmt,
+		formatSquid:    appendSquid,
+		formatCombined: appendCombined,
+	}
+)
+
+func formatNames() []string {
+	return slices.Sorted(maps.Keys(formats))
+}
+
+type jsonEntry struct {
+	Time     time.Time `json:"time"`
+	ID       string    `json:"id"`
+	Client   string    `json:"client"`
+	User     string    `json:"user,omitempty"`
+	Method   string    `json:"method"`
+	Target   string    `json:"target"`
+	Upstream string    `json:"upstream,omitempty"`
+	Status   int       `json:"status"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Duration float64   `json:"duration"`
+}
+
+func appendJSON(b []byte, e *Entry) []byte {
+	// marshaling strings and numbers cannot fail
+	encoded, _ := json.Marshal(jsonEntry{
+		Time:     e.Time,
+		ID:       e.ID,
+		Client:   e.Client,
+		User:     e.User,
+		Method:   e.Method,
+		Target:   e.Target,
+		Upstream: e.Upstream,
+		Status:   e.Status,
+		BytesIn:  e.BytesIn,
+		BytesOut: e.BytesOut,
+		Duration: e.Duration.Seconds(),
+	})
+
+	return append(b, encoded...)
+}
+
+func appendLogfmt(b []byte, e *Entry) []byte {
+	b = appendLogfmtPair(b, "time", e.Time.Format(time.RFC3339Nano))
+	b = appendLogfmtPair(b, "id", e.ID)
+	b = appendLogfmtPair(b, "client", e.Client)
+	b = appendLogfmtPair(b, "user", e.User)
+	b = appendLogfmtPair(b, "method", e.Method)
+	b = appendLogfmtPair(b, "target", e.Target)
+	b = appendLogfmtPair(b, "upstream", e.Upstream)
+	b = appendLogfmtPair(b, "status", strconv.Itoa(e.Status))
+	b = appendLogfmtPair(b, "bytes_in", strconv.FormatInt(e.BytesIn, 10))
+	b = appendLogfmtPair(b, "bytes_out", strconv.FormatInt(e.BytesOut, 10))
+	b = appendLogfmtPair(b, "duration", e.Duration.String())
+
+	return b
+}
+
+func appendLogfmtPair(b []byte, key, value string) []byte {
+	if len(b) > 0 {
+		b = append(b, ' ')
+	}
+
+	b = append(b, key...)
+	b = append(b, '=')
+
+	if value == "" || strings.ContainsAny(value, " =\"\\") || !strconv.CanBackquote(value) {
+		return strconv.AppendQuote(b, value)
+	}
+
+	return append(b, value...)
+}
+
+// appendSquid uses the native format of squid.
+// See https://wiki.squid-cache.org/Features/LogFormat
+func appendSquid(b []byte, e *Entry) []byte {
+	return fmt.Appendf(b, "%d.%03d %6d %s %s/%03d %d %s %s %s %s -",
+		e.Time.Unix(),
+		e.Time.Nanosecond()/int(time.Millisecond),
+		e.Duration.Milliseconds(),
+		dash(e.Client),
+		squidResult(e),
+		e.Status,
+		e.BytesIn,
+		e.Method,
+		dash(e.Target),
+		dash(e.User),
+		squidHierarchy(e),
+	)
+}
+
+func squidResult(e *Entry) string {
+	switch {
+	case e.Status == http.StatusForbidden, e.Status == http.StatusProxyAuthRequired:
+		return "TCP_DENIED"
+
+	case e.Method == http.MethodConnect:
+		return "TCP_TUNNEL"
+
+	default:
+		return "TCP_MISS"
+	}
+}
+
+func squidHierarchy(e *Entry) string {
+	switch {
+	case e.Upstream == "":
+		return "HIER_NONE/-"
+
+	case e.Upstream == "DIRECT":
+		return "HIER_DIRECT/" + hostOf(e.Target)
+
+	default:
+		return "FIRSTUP_PARENT/" + hostOf(e.Upstream)
+	}
+}
+
+// hostOf returns the host of an url or host:port.
+func hostOf(s string) string {
+	if _, after, ok := strings.Cut(s, "://"); ok {
+		s = after
+	}
+
+	if before, _, ok := strings.Cut(s, "/"); ok {
+		s = before
+	}
+
+	return s
+}
+
+// appendCombined uses the combined log format of apache.
+// See https://httpd.apache.org/docs/current/logs.html#combined
+func appendCombined(b []byte, e *Entry) []byte {
+	host, _, err := net.SplitHostPort(e.Client)
+	if err != nil {
+		host = e.Client
+	}
+
+	bytesIn := "-"
+	if e.BytesIn > 0 {
+		bytesIn = strconv.FormatInt(e.BytesIn, 10)
+	}
+
+	return fmt.Appendf(b, "%s - %s [%s] %s %d %s %s %s",
+		dash(host),
+		dash(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.Target+" "+e.Proto),
+		e.Status,
+		bytesIn,
+		strconv.Quote(dash(e.Referer)),
+		strconv.Quote(dash(e.UserAgent)),
+	)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
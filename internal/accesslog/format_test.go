@@ -0,0 +1,74 @@
+package accesslog
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormats(t *testing.T) {
+	entry := Entry{
+		Time:      time.Date(2025, time.March, 14, 15, 9, 26, 535_000_000, time.UTC),
+		ID:        "d0lq9b2gfc3s73c0u8sg",
+		Client:    "192.168.1.5:52314",
+		User:      "alice",
+		Method:    "GET",
+		Target:    "http://example.org/index.html",
+		Proto:     "HTTP/1.1",
+		Upstream:  "proxy://corporate:8080",
+		Status:    200,
+		BytesIn:   1024,
+		BytesOut:  12,
+		Duration:  1500 * time.Millisecond,
+		UserAgent: "curl/8.5.0",
+	}
+
+	for format, expected := range map[string]string{
+		formatJSON: `{"time":"2025-03-14T15:09:26.535Z","id":"d0lq9b2gfc3s73c0u8sg","client":"192.168.1.5:52314",` +
+			`"user":"alice","method":"GET","target":"http://example.org/index.html","upstream":"proxy://corporate:8080",` +
+			`"status":200,"bytes_in":1024,"bytes_out":12,"duration":1.5}`,
+		formatLogfmt: `time=2025-03-14T15:09:26.535Z id=d0lq9b2gfc3s73c0u8sg client=192.168.1.5:52314 user=alice ` +
+			`method=GET target=http://example.org/index.html upstream=proxy://corporate:8080 status=200 ` +
+			`bytes_in=1024 bytes_out=12 duration=1.5s`,
+		formatSquid: `1741964966.535   1500 192.168.1.5:52314 TCP_MISS/200 1024 GET http://example.org/index.html ` +
+			`alice FIRSTUP_PARENT/corporate:8080 -`,
+		formatCombined: `192.168.1.5 - alice [14/Mar/2025:15:09:26 +0000] "GET http://example.org/index.html HTTP/1.1" ` +
+			`200 1024 "-" "curl/8.5.0"`,
+	} {
+		assert.Equal(t, expected, string(formats[format](nil, &entry)), format)
+	}
+}
+
+func TestFormatsUnforwarded(t *testing.T) {
+	entry := Entry{
+		Time:   time.Date(2025, time.March, 14, 15, 9, 26, 0, time.UTC),
+		Client: "[::1]:52314",
+		Method: "CONNECT",
+		Target: "example.org:443",
+		Proto:  "HTTP/1.1",
+		Status: 403,
+	}
+
+	assert.Equal(t,
+		`1741964966.000      0 [::1]:52314 TCP_DENIED/403 0 CONNECT example.org:443 - HIER_NONE/- -`,
+		string(appendSquid(nil, &entry)))
+
+	assert.Equal(t,
+		`::1 - - [14/Mar/2025:15:09:26 +0000] "CONNECT example.org:443 HTTP/1.1" 403 - "-" "-"`,
+		string(appendCombined(nil, &entry)))
+
+	assert.Contains(t, string(appendLogfmt(nil, &entry)), ` user="" `)
+}
+
+func TestLoggerWritesLines(t *testing.T) {
+	var buf bytes.Buffer
+
+	logger := newLogger(nopCloser{&buf}, appendLogfmt)
+	logger.Log(&Entry{Method: "GET"})
+	logger.Log(&Entry{Method: "CONNECT"})
+
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("\n")))
+	assert.NoError(t, logger.Close())
+}
@@ -5,12 +5,14 @@ import (
 	"log/slog"
 
 	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
 )
 
 func init() {
-	viper.SetDefault("autoconfigure.enabled", false)
-	viper.SetDefault("autoconfigure.root", "/")
-	viper.SetDefault("autoconfigure.config.addr", "")
+	config.Define("autoconfigure.enabled", config.Bool, false)
+	config.Define("autoconfigure.root", config.String, "/")
+	config.Define("autoconfigure.config.addr", config.String, "")
 }
 
 type Root interface {
@@ -16,27 +16,39 @@ func (i *item[V]) isExpired() bool {
 }
 
 type cache[K fmt.Stringer, V any] struct {
-	mu       sync.Mutex
-	items    map[string]item[V]
-	duration time.Duration
+	mu         sync.Mutex
+	items      map[string]item[V]
+	duration   time.Duration
+	generation uint64
+	ticker     *time.Ticker
 }
 
 func newCache[K fmt.Stringer, V any](duration, gcInterval time.Duration) *cache[K, V] {
 	cache := &cache[K, V]{
 		items:    make(map[string]item[V]),
 		duration: duration,
+		ticker:   time.NewTicker(gcInterval),
 	}
 
-	go cache.schedule(gcInterval)
+	go cache.schedule()
 	return cache
 }
 
-func (c *cache[K, V]) schedule(interval time.Duration) {
-	for range time.NewTicker(interval).C {
+func (c *cache[K, V]) schedule() {
+	for range c.ticker.C {
 		c.gc()
 	}
 }
 
+// configure changes the durations. Items, that are already cached, keep their expiration.
+func (c *cache[K, V]) configure(duration, gcInterval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.duration = duration
+	c.ticker.Reset(gcInterval)
+}
+
 func (c *cache[K, V]) gc() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
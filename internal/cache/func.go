@@ -1,43 +1,133 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
 )
 
 func init() {
-	viper.SetDefault("cache.duration.item", "30m")
-	viper.SetDefault("cache.interval.gc", "15m")
+	config.Define("cache.duration.item", config.Duration, "30m")
+	config.Define("cache.interval.gc", config.Duration, "15m")
+}
+
+var (
+	// ErrUncached is returned by the cached function along with a value, that is passed to the caller
+	// without being stored.
+	ErrUncached = errors.New("value must not be cached")
+)
+
+// Func caches the results of a function by key.
+type Func[K fmt.Stringer, V any] struct {
+	fn    func(K) (V, error)
+	cache *cache[K, V]
 }
 
-func Func[K fmt.Stringer, V any](fn func(K) (V, error)) func(K) (V, error) {
-	cache := newCache[K, V](
+func NewFunc[K fmt.Stringer, V any](fn func(K) (V, error)) *Func[K, V] {
+	return &Func[K, V]{
+		fn: fn,
+		cache: newCache[K, V](
+			viper.GetDuration("cache.duration.item"),
+			viper.GetDuration("cache.interval.gc"),
+		),
+	}
+}
+
+// Reload applies the configured durations.
+func (f *Func[K, V]) Reload() {
+	f.cache.configure(
 		viper.GetDuration("cache.duration.item"),
 		viper.GetDuration("cache.interval.gc"),
 	)
+}
 
-	return func(key K) (V, error) {
-		cache.mu.Lock()
-		defer cache.mu.Unlock()
+func (f *Func[K, V]) Call(key K) (V, error) {
+	value, generation, ok := f.lookup(key)
+	if ok {
+		metrics.CacheRequests.WithLabelValues(metrics.ResultHit).Inc()
+		slog.Debug("return value from cache",
+			slog.Any("key", key),
+			slog.Any("value", value),
+		)
 
-		if value, ok := cache.get(key); ok {
-			slog.Debug("return value from cache",
-				slog.Any("key", key),
-				slog.Any("value", value),
-			)
+		return value, nil
+	}
 
-			return value, nil
-		}
+	metrics.CacheRequests.WithLabelValues(metrics.ResultMiss).Inc()
+	slog.Debug("value missing from cache", slog.Any("key", key))
 
-		slog.Debug("value missing from cache", slog.Any("key", key))
+	// The lock is not held while calling fn, so that slow calls do not block other keys.
+	value, err := f.fn(key)
+	if errors.Is(err, ErrUncached) {
+		return value, nil
+	}
 
-		value, err := fn(key)
-		if err == nil {
-			cache.put(key, value)
-		}
+	if err == nil {
+		f.store(key, value, generation)
+	}
+
+	return value, err
+}
+
+func (f *Func[K, V]) lookup(key K) (V, uint64, bool) {
+	f.cache.mu.Lock()
+	defer f.cache.mu.Unlock()
 
-		return value, err
+	value, ok := f.cache.get(key)
+	return value, f.cache.generation, ok
+}
+
+func (f *Func[K, V]) store(key K, value V, generation uint64) {
+	f.cache.mu.Lock()
+	defer f.cache.mu.Unlock()
+
+	// Do not store values, that were computed before the cache was flushed.
+	if generation == f.cache.generation {
+		f.cache.put(key, value)
+	}
+}
+
+// Entry is a cached value.
+type Entry[V any] struct {
+	Key        string    `json:"key"`
+	Value      V         `json:"value"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Entries returns the values, that are not expired yet, ordered by key.
+func (f *Func[K, V]) Entries() []Entry[V] {
+	f.cache.mu.Lock()
+	defer f.cache.mu.Unlock()
+
+	entries := make([]Entry[V], 0, len(f.cache.items))
+	for key, item := range f.cache.items {
+		if !item.isExpired() {
+			entries = append(entries, Entry[V]{Key: key, Value: item.value, Expiration: item.expiration})
+		}
 	}
+
+	slices.SortFunc(entries, func(a, b Entry[V]) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return entries
+}
+
+// Flush removes all cached values.
+func (f *Func[K, V]) Flush() {
+	f.cache.mu.Lock()
+	defer f.cache.mu.Unlock()
+
+	slog.Debug("flushing cache")
+
+	clear(f.cache.items)
+	f.cache.generation++
 }
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey string
+
+func (k testKey) String() string {
+	return string(k)
+}
+
+func newTestFunc(t testing.TB, duration time.Duration, fn func(f *Func[testKey, int], calls int) (int, error)) (*Func[testKey, int], *int) {
+	viper.Set("cache.duration.item", duration)
+	t.Cleanup(func() { viper.Set("cache.duration.item", "30m") })
+
+	var (
+		f     *Func[testKey, int]
+		calls int
+	)
+
+	f = NewFunc(func(testKey) (int, error) {
+		calls++
+		return fn(f, calls)
+	})
+
+	return f, &calls
+}
+
+func TestFunc(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	for _, tc := range []struct {
+		name     string
+		duration time.Duration
+		fn       func(f *Func[testKey, int], calls int) (int, error)
+		values   []int
+		err      error
+		entries  int
+	}{
+		{
+			name:     "cached",
+			duration: time.Hour,
+			fn:       func(_ *Func[testKey, int], calls int) (int, error) { return calls, nil },
+			values:   []int{1, 1},
+			entries:  1,
+		},
+		{
+			name:     "uncached",
+			duration: time.Hour,
+			fn:       func(_ *Func[testKey, int], calls int) (int, error) { return calls, ErrUncached },
+			values:   []int{1, 2},
+		},
+		{
+			name:     "failed",
+			duration: time.Hour,
+			fn:       func(_ *Func[testKey, int], calls int) (int, error) { return calls, errFailed },
+			values:   []int{1, 2},
+			err:      errFailed,
+		},
+		{
+			name:     "expired",
+			duration: time.Nanosecond,
+			fn:       func(_ *Func[testKey, int], calls int) (int, error) { return calls, nil },
+			values:   []int{1, 2},
+		},
+		{
+			// the value was computed before the flush, so it belongs to a stale generation
+			name:     "flushed while computing",
+			duration: time.Hour,
+			fn: func(f *Func[testKey, int], calls int) (int, error) {
+				if calls == 1 {
+					f.Flush()
+				}
+
+				return calls, nil
+			},
+			values:  []int{1, 2, 2},
+			entries: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, _ := newTestFunc(t, tc.duration, tc.fn)
+
+			for _, expected := range tc.values {
+				value, err := f.Call("key")
+				assert.ErrorIs(t, err, tc.err)
+				assert.Equal(t, expected, value)
+
+				time.Sleep(time.Millisecond)
+			}
+
+			assert.Len(t, f.Entries(), tc.entries)
+		})
+	}
+}
+
+func TestFuncReload(t *testing.T) {
+	f, calls := newTestFunc(t, time.Hour, func(_ *Func[testKey, int], calls int) (int, error) { return calls, nil })
+
+	_, _ = f.Call("key")
+
+	// cached values keep their expiration
+	viper.Set("cache.duration.item", time.Nanosecond)
+	f.Reload()
+
+	_, _ = f.Call("key")
+	assert.Equal(t, 1, *calls)
+
+	_, _ = f.Call("other")
+	time.Sleep(time.Millisecond)
+	_, _ = f.Call("other")
+	assert.Equal(t, 3, *calls)
+
+	// values computed before a flush are not stored, even if the flush is followed by a reload
+	generation := f.cache.generation
+	f.Flush()
+
+	viper.Set("cache.duration.item", time.Hour)
+	f.Reload()
+
+	f.store("stale", 1, generation)
+	assert.Empty(t, f.Entries())
+
+	f.store("current", 1, f.cache.generation)
+	assert.Len(t, f.Entries(), 1)
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// UnmarshalKey decodes a structured setting like a list of rules into v. Environment variables
+// cannot express structures, so string values are decoded as json first.
+func UnmarshalKey(key string, v any) error {
+	value := viper.Get(key)
+	if value == nil {
+		value = schema[key].value
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           v,
+		WeaklyTypedInput: true,
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			jsonStringHook,
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(value)
+}
+
+func jsonStringHook(from, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+
+	switch to.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+	default:
+		return data, nil
+	}
+
+	source := strings.TrimSpace(data.(string))
+	if source == "" {
+		if to.Kind() == reflect.Slice {
+			return []any{}, nil
+		}
+
+		return map[string]any{}, nil
+	}
+
+	if !strings.HasPrefix(source, "[") && !strings.HasPrefix(source, "{") {
+		return data, nil
+	}
+
+	var decoded any
+	if err := json.Unmarshal([]byte(source), &decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
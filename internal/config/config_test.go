@@ -0,0 +1,37 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type rule struct {
+	Name    string        `mapstructure:"name"`
+	Ports   []string      `mapstructure:"ports"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func TestUnmarshalKey(t *testing.T) {
+	defer viper.Set("test.rules", nil)
+
+	var rules []rule
+
+	viper.Set("test.rules", "")
+	assert.NoError(t, UnmarshalKey("test.rules", &rules))
+	assert.Empty(t, rules)
+
+	viper.Set("test.rules", `[{"name": "a", "ports": "80,443", "timeout": "1s"}]`)
+	assert.NoError(t, UnmarshalKey("test.rules", &rules))
+	assert.Equal(t, []rule{{Name: "a", Ports: []string{"80", "443"}, Timeout: time.Second}}, rules)
+
+	rules = nil
+	viper.Set("test.rules", []any{map[string]any{"name": "b", "ports": []any{"22"}}})
+	assert.NoError(t, UnmarshalKey("test.rules", &rules))
+	assert.Equal(t, []rule{{Name: "b", Ports: []string{"22"}}}, rules)
+
+	viper.Set("test.rules", `[{"name": `)
+	assert.Error(t, UnmarshalKey("test.rules", &rules))
+}
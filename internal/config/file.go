@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/viper"
+)
+
+func init() {
+	Define("config", String, "")
+}
+
+const (
+	envPrefix = "PROXYPROXY"
+
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+
+	masked = "********"
+)
+
+var (
+	envKeyReplacer = strings.NewReplacer(".", "_")
+	// renamed maps settings to their former keys, whose environment variables are still read.
+	renamed = make(map[string]string)
+	// file holds the settings of the config file, before they are cast to the type of their
+	// defaults, so that invalid values can be reported.
+	file *viper.Viper
+)
+
+// Setup reads settings from environment variables and the config file. The path of the config
+// file is either passed explicitly or read from the config setting. Environment variables take
+// precedence over the config file. The format is derived from the file extension, e.g. yaml or
+// toml.
+func Setup(path string) error {
+	viper.SetTypeByDefaultValue(true)
+	viper.AutomaticEnv()
+	viper.SetEnvKeyReplacer(envKeyReplacer)
+	viper.SetEnvPrefix(envPrefix)
+
+	if path == "" {
+		path = viper.GetString("config")
+	}
+
+	if path == "" {
+		return nil
+	}
+
+	viper.SetConfigFile(path)
+
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	file = viper.New()
+	file.SetConfigFile(path)
+
+	return file.ReadInConfig()
+}
+
+// Rename keeps reading the environment variable of the former key of a setting, so that existing
+// deployments keep working. The former key is not accepted in the config file.
+func Rename(former, key string) {
+	renamed[key] = former
+
+	if err := viper.BindEnv(key, envKey(key), envKey(former)); err != nil {
+		panic(err)
+	}
+}
+
+func envKey(key string) string {
+	return envPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
+
+// lookupEnv returns the environment variable of a setting, falling back to its former key.
+func lookupEnv(key string) (string, bool) {
+	if value, ok := os.LookupEnv(envKey(key)); ok {
+		return value, true
+	}
+
+	if former, ok := renamed[key]; ok {
+		return os.LookupEnv(envKey(former))
+	}
+
+	return "", false
+}
+
+// source returns where the effective value of a setting comes from.
+func source(key string) string {
+	if _, ok := lookupEnv(key); ok {
+		return sourceEnv
+	}
+
+	if file != nil && file.InConfig(key) {
+		return sourceFile
+	}
+
+	return sourceDefault
+}
+
+// rawValue returns the effective value of a setting without casting it.
+func rawValue(key string) any {
+	switch source(key) {
+	case sourceEnv:
+		value, _ := lookupEnv(key)
+		return value
+
+	case sourceFile:
+		return file.Get(key)
+
+	default:
+		return schema[key].value
+	}
+}
+
+// Print writes all settings with their effective value and source. Secrets are masked.
+func Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+
+	for _, key := range keys() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, formatValue(key), source(key))
+	}
+
+	return tw.Flush()
+}
+
+func formatValue(key string) string {
+	value := rawValue(key)
+
+	if schema[key].kind == Secret && value != "" {
+		return masked
+	}
+
+	if value == "" {
+		return `""`
+	}
+
+	return stringify(value)
+}
+
+func stringify(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+
+	case []any, map[string]any:
+		// structures from the config file are printed as they would be set using env
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Sprint(value)
+		}
+
+		return string(encoded)
+
+	default:
+		return fmt.Sprint(value)
+	}
+}
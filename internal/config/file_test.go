@@ -0,0 +1,137 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTest(t *testing.T, name, content string) {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	Define("test.addr", String, ":8080")
+	Define("test.token", Secret, "")
+	Define("test.timeout.read", Duration, "30s")
+	Define("test.enabled", Bool, false)
+	Define("test.limit", Size, "640k")
+	Define("test.rules", Structure, "")
+
+	t.Cleanup(func() {
+		viper.Reset()
+		file = nil
+		validators = nil
+
+		for key := range schema {
+			if strings.HasPrefix(key, "test.") {
+				delete(schema, key)
+			}
+		}
+
+		for key := range renamed {
+			if strings.HasPrefix(key, "test.") {
+				delete(renamed, key)
+			}
+		}
+	})
+
+	assert.NoError(t, Setup(path))
+}
+
+func TestSetupFormats(t *testing.T) {
+	for name, content := range map[string]string{
+		"config.yaml": "test:\n  addr: :3128\n  rules:\n    - name: a\n      ports: [\"22\"]\n",
+		"config.toml": "[test]\naddr = \":3128\"\n\n[[test.rules]]\nname = \"a\"\nports = [\"22\"]\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			setupTest(t, name, content)
+
+			assert.Equal(t, ":3128", viper.GetString("test.addr"))
+			assert.Equal(t, "30s", viper.GetString("test.timeout.read"))
+
+			var rules []rule
+			assert.NoError(t, UnmarshalKey("test.rules", &rules))
+			assert.Equal(t, []rule{{Name: "a", Ports: []string{"22"}}}, rules)
+			assert.NoError(t, Validate())
+		})
+	}
+}
+
+func TestSetupEnvPrecedence(t *testing.T) {
+	t.Setenv("PROXYPROXY_TEST_ADDR", ":9090")
+	setupTest(t, "config.yaml", "test:\n  addr: :3128\n")
+
+	assert.Equal(t, ":9090", viper.GetString("test.addr"))
+	assert.Equal(t, sourceEnv, source("test.addr"))
+}
+
+func TestRename(t *testing.T) {
+	t.Setenv("PROXYPROXY_TEST_TIMEOUT_READ_HEADER", "5s")
+	setupTest(t, "config.yaml", "test:\n  timeout:\n    read: 20s\n    header: 15s\n")
+
+	Define("test.timeout.header", Duration, "10s")
+	Rename("test.timeout.read.header", "test.timeout.header")
+
+	assert.Equal(t, 20*time.Second, viper.GetDuration("test.timeout.read"))
+	assert.Equal(t, 5*time.Second, viper.GetDuration("test.timeout.header"))
+	assert.Equal(t, sourceEnv, source("test.timeout.header"))
+	assert.Equal(t, "5s", rawValue("test.timeout.header"))
+	assert.NoError(t, Validate())
+}
+
+func TestValidateReportsAllErrors(t *testing.T) {
+	setupTest(t, "config.yaml", strings.Join([]string{
+		"test:",
+		"  adr: :3128",
+		"  timeout:",
+		"    read: soon",
+		"  enabled: maybe",
+		"  limit: huge",
+	}, "\n"))
+
+	AddValidator(func() error {
+		return errors.New("invalid test.rules: custom")
+	})
+
+	err := Validate()
+	assert.Error(t, err)
+
+	for _, key := range []string{"test.adr", "test.timeout.read", "test.enabled", "test.limit", "test.rules"} {
+		assert.ErrorContains(t, err, key)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	setupTest(t, "config.yaml", "test:\n  token: secret\n  rules:\n    - name: a\n")
+
+	var out strings.Builder
+	assert.NoError(t, Print(&out))
+
+	lines := make(map[string][]string)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 3 {
+			lines[fields[0]] = fields[1:]
+		}
+	}
+
+	assert.Equal(t, []string{":8080", sourceDefault}, lines["test.addr"])
+	assert.Equal(t, []string{masked, sourceFile}, lines["test.token"])
+	assert.Equal(t, []string{`[{"name":"a"}]`, sourceFile}, lines["test.rules"])
+	assert.NotContains(t, out.String(), "secret")
+}
+
+func TestCheckSize(t *testing.T) {
+	for _, valid := range []string{"640", "640k", "640KB", "100mb", "1 g", "12b"} {
+		assert.NoError(t, checkSize(valid), valid)
+	}
+
+	for _, invalid := range []string{"", "huge", "1.5mb", "-1k", "10tb"} {
+		assert.Error(t, checkSize(invalid), invalid)
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// Kind describes the type of a setting, which is checked by Validate.
+type Kind int
+
+const (
+	String Kind = iota
+	// Secret is a string, that is masked when printed.
+	Secret
+	Bool
+	Int
+	Duration
+	// Size is a number of bytes with an optional unit like 640k or 100mb.
+	Size
+	// Structure is a list or map decoded using UnmarshalKey.
+	Structure
+)
+
+type setting struct {
+	kind  Kind
+	value any
+}
+
+var (
+	schema     = make(map[string]setting)
+	validators []func() error
+)
+
+// Define declares a setting with its kind and default value. Settings, that are not defined, are
+// rejected in the config file.
+func Define(key string, kind Kind, value any) {
+	schema[key] = setting{kind: kind, value: value}
+
+	// viper would cast lists from the config file to the type of the default
+	if kind != Structure {
+		viper.SetDefault(key, value)
+	}
+}
+
+// AddValidator registers a check for settings, that cannot be expressed by a kind alone. The
+// returned error should name the invalid key.
+func AddValidator(validate func() error) {
+	validators = append(validators, validate)
+}
+
+// Validate checks all settings and returns every error instead of stopping at the first one.
+func Validate() error {
+	var errs []error
+
+	if file != nil {
+		for _, key := range file.AllKeys() {
+			if _, ok := schema[key]; !ok {
+				errs = append(errs, fmt.Errorf("unknown key %s in %s", key, file.ConfigFileUsed()))
+			}
+		}
+	}
+
+	for _, key := range keys() {
+		if err := checkKind(key, schema[key].kind); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", key, err))
+		}
+	}
+
+	for _, validate := range validators {
+		errs = append(errs, validate())
+	}
+
+	return errors.Join(errs...)
+}
+
+func keys() []string {
+	return slices.Sorted(maps.Keys(schema))
+}
+
+func checkKind(key string, kind Kind) error {
+	value := rawValue(key)
+
+	var err error
+	switch kind {
+	case Bool:
+		_, err = cast.ToBoolE(value)
+	case Int:
+		_, err = cast.ToIntE(value)
+	case Duration:
+		_, err = cast.ToDurationE(value)
+	case Size:
+		err = checkSize(cast.ToString(value))
+	}
+
+	return err
+}
+
+// checkSize accepts the same units as viper.GetSizeInBytes, which silently returns 0 otherwise.
+func checkSize(s string) error {
+	number := strings.ToLower(strings.TrimSpace(s))
+
+	for _, unit := range []string{"kb", "mb", "gb", "k", "m", "g", "b"} {
+		if trimmed, ok := strings.CutSuffix(number, unit); ok {
+			number = trimmed
+			break
+		}
+	}
+
+	if _, err := strconv.ParseUint(strings.TrimSpace(number), 10, 64); err != nil {
+		return fmt.Errorf("%q is not a size like 640k or 100mb", s)
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Reloader applies the settings named by Keys to a running component. Components must not read
+// viper while serving requests, since it is not safe to read while the config file is reloaded.
+// Instead, Apply replaces the settings held by the component.
+type Reloader struct {
+	Keys  []string
+	Apply func() error
+}
+
+var (
+	// watchMu serializes reloads, since viper may report a single write as multiple events.
+	watchMu sync.Mutex
+)
+
+// Watch reloads the config file, whenever it changes. Changed settings are applied using the
+// reloaders, while changes of all other settings are reported as requiring a restart. An invalid
+// file is ignored as a whole and the previous settings stay in effect.
+func Watch(reloaders ...Reloader) {
+	if file == nil {
+		return
+	}
+
+	path := file.ConfigFileUsed()
+
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		slog.Warn("not watching config file", slog.String("path", path), slog.Any("err", err))
+		return
+	}
+
+	values := snapshot()
+
+	viper.OnConfigChange(func(fsnotify.Event) {
+		watchMu.Lock()
+		defer watchMu.Unlock()
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			slog.Warn("could not read changed config file", slog.String("path", path), slog.Any("err", err))
+			return
+		}
+
+		if bytes.Equal(content, previous) {
+			return
+		}
+
+		// editors may truncate the file before writing it, which would reset every setting
+		if len(bytes.TrimSpace(content)) == 0 {
+			restore(previous)
+			return
+		}
+
+		if err := reload(content); err != nil {
+			for _, err := range unjoin(err) {
+				slog.Error("ignoring invalid config file", slog.String("path", path), slog.Any("err", err))
+			}
+
+			restore(previous)
+			return
+		}
+
+		previous = content
+		current := snapshot()
+
+		for _, key := range apply(changed(values, current), reloaders) {
+			slog.Warn("setting changed, restart required to apply it", slog.String("key", key))
+		}
+
+		values = current
+	})
+
+	slog.Info("watching config file for changes", slog.String("path", path))
+	viper.WatchConfig()
+}
+
+// reload replaces the settings of the config file with content and validates them.
+func reload(content []byte) error {
+	if err := viper.ReadConfig(bytes.NewReader(content)); err != nil {
+		return err
+	}
+
+	if err := file.ReadConfig(bytes.NewReader(content)); err != nil {
+		return err
+	}
+
+	return Validate()
+}
+
+// restore reads the previous content again, since viper already read the changed file.
+func restore(previous []byte) {
+	if err := reload(previous); err != nil {
+		slog.Error("could not restore previous config", slog.Any("err", err))
+	}
+}
+
+// snapshot returns the effective value of every setting.
+func snapshot() map[string]string {
+	values := make(map[string]string, len(schema))
+	for _, key := range keys() {
+		values[key] = stringify(rawValue(key))
+	}
+
+	return values
+}
+
+func changed(previous, current map[string]string) []string {
+	var keys []string
+	for key, value := range current {
+		if previous[key] != value {
+			keys = append(keys, key)
+		}
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+// apply calls the reloaders of the changed keys and returns the keys, that require a restart.
+func apply(keys []string, reloaders []Reloader) []string {
+	restart := slices.Clone(keys)
+
+	for _, reloader := range reloaders {
+		applied := slices.DeleteFunc(slices.Clone(keys), func(key string) bool {
+			return !slices.Contains(reloader.Keys, key)
+		})
+
+		if len(applied) == 0 {
+			continue
+		}
+
+		restart = slices.DeleteFunc(restart, func(key string) bool {
+			return slices.Contains(applied, key)
+		})
+
+		if err := reloader.Apply(); err != nil {
+			slog.Error("could not apply changed settings", slog.Any("keys", applied), slog.Any("err", err))
+			continue
+		}
+
+		slog.Info("applied changed settings", slog.Any("keys", applied))
+	}
+
+	return restart
+}
+
+func unjoin(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+
+	return []error{err}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	var applied []string
+
+	reloaders := []Reloader{
+		{Keys: []string{"test.addr"}, Apply: func() error {
+			applied = append(applied, "addr")
+			return nil
+		}},
+		{Keys: []string{"test.token", "test.rules"}, Apply: func() error {
+			applied = append(applied, "auth")
+			return errors.New("failed")
+		}},
+		{Keys: []string{"test.limit"}, Apply: func() error {
+			applied = append(applied, "limit")
+			return nil
+		}},
+	}
+
+	restart := apply([]string{"test.enabled", "test.limit", "test.rules"}, reloaders)
+
+	assert.Equal(t, []string{"auth", "limit"}, applied)
+	assert.Equal(t, []string{"test.enabled"}, restart)
+}
+
+func TestChanged(t *testing.T) {
+	assert.Equal(t, []string{"a", "c"}, changed(
+		map[string]string{"a": "1", "b": "2"},
+		map[string]string{"a": "2", "b": "2", "c": "3"},
+	))
+}
+
+func TestWatch(t *testing.T) {
+	setupTest(t, "config.yaml", "test:\n  addr: :3128\n")
+
+	reloaded := make(chan string, 1)
+	Watch(Reloader{
+		Keys: []string{"test.addr"},
+		Apply: func() error {
+			reloaded <- viper.GetString("test.addr")
+			return nil
+		},
+	})
+
+	path := viper.ConfigFileUsed()
+
+	assert.NoError(t, os.WriteFile(path, []byte("test:\n  addr: :9090\n"), 0600))
+	assert.Equal(t, ":9090", receive(t, reloaded))
+
+	// invalid files are ignored as a whole
+	assert.NoError(t, os.WriteFile(path, []byte("test:\n  addr: :8081\n  limit: huge\n"), 0600))
+	assert.Never(t, func() bool { return len(reloaded) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	watchMu.Lock()
+	assert.Equal(t, ":9090", viper.GetString("test.addr"))
+	watchMu.Unlock()
+
+	// truncated files are ignored as well
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+	assert.Never(t, func() bool { return len(reloaded) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+	watchMu.Lock()
+	assert.Equal(t, ":9090", viper.GetString("test.addr"))
+	watchMu.Unlock()
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	select {
+	case value := <-ch:
+		return value
+
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for reload")
+		return ""
+	}
+}
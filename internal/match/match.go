@@ -0,0 +1,165 @@
+package match
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Destination matches a host and port. It is embedded by rules, that decide by the destination of
+// a request. Every configured criterion has to match, while any entry of a criterion is sufficient.
+type Destination struct {
+	// Hosts are globs like "*.example.org".
+	Hosts []string `mapstructure:"hosts"`
+	// Networks are matched against the ip addresses the host resolves to.
+	Networks []string `mapstructure:"networks"`
+	// Ports are single ports or ranges like "8000-8999".
+	Ports []string `mapstructure:"ports"`
+
+	hosts    []glob.Glob
+	networks []netip.Prefix
+	ports    [][2]int
+}
+
+// Compile parses the criteria and has to be called before matching.
+func (d *Destination) Compile() error {
+	for _, host := range d.Hosts {
+		matcher, err := glob.Compile(strings.ToLower(strings.TrimSpace(host)))
+		if err != nil {
+			return err
+		}
+
+		d.hosts = append(d.hosts, matcher)
+	}
+
+	for _, network := range d.Networks {
+		prefix, err := ParsePrefix(strings.TrimSpace(network))
+		if err != nil {
+			return err
+		}
+
+		d.networks = append(d.networks, prefix)
+	}
+
+	for _, port := range d.Ports {
+		portRange, err := parsePortRange(strings.TrimSpace(port))
+		if err != nil {
+			return err
+		}
+
+		d.ports = append(d.ports, portRange)
+	}
+
+	return nil
+}
+
+// Matches reports whether the lowercase host and the port match. The host is only resolved, if
+// networks are configured. Errors of resolve are returned, since the networks cannot be checked.
+func (d *Destination) Matches(host string, port int, resolve func() ([]netip.Addr, error)) (bool, error) {
+	if len(d.ports) > 0 && !d.matchesPort(port) {
+		return false, nil
+	}
+
+	if len(d.hosts) > 0 && !d.matchesHost(host) {
+		return false, nil
+	}
+
+	if len(d.networks) > 0 {
+		addrs, err := resolve()
+		if err != nil {
+			return false, err
+		}
+
+		return d.matchesAddrs(addrs), nil
+	}
+
+	return true, nil
+}
+
+func (d *Destination) matchesPort(port int) bool {
+	for _, portRange := range d.ports {
+		if port >= portRange[0] && port <= portRange[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Destination) matchesHost(host string) bool {
+	for _, matcher := range d.hosts {
+		if matcher.Match(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *Destination) matchesAddrs(addrs []netip.Addr) bool {
+	for _, addr := range addrs {
+		if ContainsAddr(d.networks, addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParsePrefix parses a cidr or a single ip address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+
+	return prefix.Masked(), nil
+}
+
+// ContainsAddr reports whether any of the prefixes contains the address.
+func ContainsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parsePortRange(s string) ([2]int, error) {
+	first, last, isRange := strings.Cut(s, "-")
+
+	from, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid port %q", s)
+	}
+
+	if !isRange {
+		return [2]int{int(from), int(from)}, nil
+	}
+
+	to, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || to < from {
+		return [2]int{}, fmt.Errorf("invalid port range %q", s)
+	}
+
+	return [2]int{int(from), int(to)}, nil
+}
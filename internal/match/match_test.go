@@ -0,0 +1,57 @@
+package match
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestination(t *testing.T) {
+	d := Destination{
+		Hosts:    []string{"*.example.org"},
+		Networks: []string{"10.0.0.0/8", "::ffff:192.168.0.0/112"},
+		Ports:    []string{"443", "8000-8999"},
+	}
+	assert.NoError(t, d.Compile())
+
+	resolve := func(addr string) func() ([]netip.Addr, error) {
+		return func() ([]netip.Addr, error) {
+			return []netip.Addr{netip.MustParseAddr(addr)}, nil
+		}
+	}
+
+	for _, tc := range []struct {
+		host    string
+		port    int
+		addr    string
+		matches bool
+	}{
+		{"www.example.org", 443, "10.1.2.3", true},
+		{"www.example.org", 8080, "192.168.1.1", true},
+		{"www.example.org", 80, "10.1.2.3", false},
+		{"www.example.com", 443, "10.1.2.3", false},
+		{"www.example.org", 443, "172.16.0.1", false},
+	} {
+		matches, err := d.Matches(tc.host, tc.port, resolve(tc.addr))
+		assert.NoError(t, err)
+		assert.Equal(t, tc.matches, matches, tc)
+	}
+
+	_, err := d.Matches("www.example.org", 443, func() ([]netip.Addr, error) {
+		return nil, errors.New("no such host")
+	})
+	assert.Error(t, err)
+}
+
+func TestDestinationInvalid(t *testing.T) {
+	for _, d := range []Destination{
+		{Hosts: []string{"["}},
+		{Networks: []string{"10.0.0.0/33"}},
+		{Ports: []string{"70000"}},
+		{Ports: []string{"90-80"}},
+	} {
+		assert.Error(t, d.Compile(), d)
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("metrics.enabled", config.Bool, true)
+}
+
+const namespace = "proxyproxy"
+
+const (
+	DecisionDirect   = "direct"
+	DecisionUpstream = "upstream"
+
+	DirectionIn  = "in"
+	DirectionOut = "out"
+
+	ResultHit  = "hit"
+	ResultMiss = "miss"
+)
+
+var (
+	// Requests counts proxied requests by method and whether they were sent directly or through an
+	// upstream proxy.
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Proxied requests by method and decision.",
+	}, []string{"method", "decision"})
+
+	// TunnelDuration measures how long CONNECT tunnels stay open.
+	TunnelDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tunnel_duration_seconds",
+		Help:      "Duration of CONNECT tunnels.",
+		Buckets:   []float64{0.1, 1, 5, 15, 60, 300, 900, 3600},
+	})
+
+	// TransferredBytes counts the bytes sent to the target (out) and received from it (in).
+	TransferredBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transferred_bytes_total",
+		Help:      "Bytes transferred by direction.",
+	}, []string{"direction"})
+
+	// PacEvaluationDuration measures the evaluation of the pac, excluding override rules.
+	PacEvaluationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pac_evaluation_duration_seconds",
+		Help:      "Duration of pac evaluations.",
+		Buckets:   []float64{0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5},
+	})
+
+	// CacheRequests counts lookups of cached upstream proxies by result.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by result.",
+	}, []string{"result"})
+
+	// UpstreamErrors counts failed connections to and refused tunnels of upstream proxies.
+	UpstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Errors of upstream proxies by host.",
+	}, []string{"upstream"})
+)
+
+// Enabled reports whether the metrics should be served.
+func Enabled() bool {
+	return viper.GetBool("metrics.enabled")
+}
+
+// Handler serves the metrics in the prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,221 @@
+package ntlm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // the nt hash is defined using md4
+)
+
+// See https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp
+
+const (
+	negotiateType    = 1
+	challengeType    = 2
+	authenticateType = 3
+
+	flagUnicode                 = 0x00000001
+	flagOEM                     = 0x00000002
+	flagRequestTarget           = 0x00000004
+	flagNTLM                    = 0x00000200
+	flagAlwaysSign              = 0x00008000
+	flagExtendedSessionSecurity = 0x00080000
+	flagTargetInfo              = 0x00800000
+	flag128                     = 0x20000000
+	flag56                      = 0x80000000
+
+	negotiateFlags = flagUnicode | flagOEM | flagRequestTarget | flagNTLM | flagAlwaysSign |
+		flagExtendedSessionSecurity | flagTargetInfo | flag128 | flag56
+
+	avTimestamp = 7
+	avEOL       = 0
+
+	challengeHeaderLength    = 48
+	authenticateHeaderLength = 64
+)
+
+var (
+	signature = []byte("NTLMSSP\x00")
+
+	// windowsEpoch is the start of the windows FILETIME, which counts 100ns intervals.
+	windowsEpoch = time.Date(1601, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	// ErrInvalidChallenge is returned, if the server sent a malformed challenge message.
+	ErrInvalidChallenge = errors.New("ntlm: invalid challenge message")
+)
+
+// Negotiate returns the first message of the handshake.
+func Negotiate() []byte {
+	message := header(negotiateType)
+	message = binary.LittleEndian.AppendUint32(message, negotiateFlags)
+
+	// empty domain and workstation
+	return append(message, make([]byte, 16)...)
+}
+
+// challenge is the second message of the handshake, sent by the server.
+type challenge struct {
+	flags      uint32
+	server     [8]byte
+	targetInfo []byte
+}
+
+func parseChallenge(message []byte) (*challenge, error) {
+	if len(message) < challengeHeaderLength ||
+		!bytes.Equal(message[:8], signature) ||
+		binary.LittleEndian.Uint32(message[8:]) != challengeType {
+		return nil, ErrInvalidChallenge
+	}
+
+	var c challenge
+	c.flags = binary.LittleEndian.Uint32(message[20:])
+	copy(c.server[:], message[24:32])
+
+	targetInfo, ok := field(message, 40)
+	if !ok {
+		return nil, ErrInvalidChallenge
+	}
+
+	c.targetInfo = targetInfo
+	return &c, nil
+}
+
+// timestamp returns the server time from the target info, if present.
+func (c *challenge) timestamp() ([]byte, bool) {
+	info := c.targetInfo
+
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		length := int(binary.LittleEndian.Uint16(info[2:]))
+
+		if id == avEOL || len(info) < 4+length {
+			break
+		}
+
+		if id == avTimestamp && length == 8 {
+			return info[4:12], true
+		}
+
+		info = info[4+length:]
+	}
+
+	return nil, false
+}
+
+// Authenticate answers the challenge of the server using NTLMv2. The username may contain the
+// domain as in "DOMAIN\user", which takes precedence over domain.
+func Authenticate(challengeMessage []byte, domain, username, password string) ([]byte, error) {
+	c, err := parseChallenge(challengeMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	if before, after, ok := strings.Cut(username, `\`); ok {
+		domain, username = before, after
+	}
+
+	var client [8]byte
+	if _, err := rand.Read(client[:]); err != nil {
+		return nil, err
+	}
+
+	timestamp, ok := c.timestamp()
+	if !ok {
+		timestamp = binary.LittleEndian.AppendUint64(nil, uint64(time.Since(windowsEpoch)/100))
+	}
+
+	key := ntowfv2(domain, username, password)
+	ntResponse := ntChallengeResponse(key, c.server, client, timestamp, c.targetInfo)
+	lmResponse := append(hmacMD5(key, c.server[:], client[:]), client[:]...)
+
+	fields := [][]byte{
+		lmResponse,
+		ntResponse,
+		encodeString(domain),
+		encodeString(username),
+		nil, // workstation
+		nil, // encrypted random session key
+	}
+
+	message := header(authenticateType)
+	offset := authenticateHeaderLength
+
+	for _, value := range fields {
+		message = binary.LittleEndian.AppendUint16(message, uint16(len(value)))
+		message = binary.LittleEndian.AppendUint16(message, uint16(len(value)))
+		message = binary.LittleEndian.AppendUint32(message, uint32(offset))
+		offset += len(value)
+	}
+
+	message = binary.LittleEndian.AppendUint32(message, c.flags&negotiateFlags|flagUnicode)
+
+	for _, value := range fields {
+		message = append(message, value...)
+	}
+
+	return message, nil
+}
+
+func ntChallengeResponse(key []byte, server, client [8]byte, timestamp, targetInfo []byte) []byte {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = append(temp, timestamp...)
+	temp = append(temp, client[:]...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	proof := hmacMD5(key, server[:], temp)
+	return append(proof, temp...)
+}
+
+func ntowfv2(domain, username, password string) []byte {
+	hash := md4.New()
+	hash.Write(encodeString(password))
+
+	return hmacMD5(hash.Sum(nil), encodeString(strings.ToUpper(username)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+func header(messageType uint32) []byte {
+	return binary.LittleEndian.AppendUint32(append([]byte{}, signature...), messageType)
+}
+
+// field reads the security buffer at offset, which references a slice of the payload.
+func field(message []byte, offset int) ([]byte, bool) {
+	if len(message) < offset+8 {
+		return nil, false
+	}
+
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+
+	if start+length > len(message) {
+		return nil, false
+	}
+
+	return message[start : start+length], true
+}
+
+func encodeString(s string) []byte {
+	var encoded []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, r)
+	}
+
+	return encoded
+}
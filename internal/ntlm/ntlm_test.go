@@ -0,0 +1,39 @@
+package ntlm
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/ntlm/ntlmtest"
+)
+
+func TestNTOWFv2(t *testing.T) {
+	// See https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-nlmp/7795bd0e-fd5e-43ec-bd9c-994704d8ee26
+	assert.Equal(t, "0c868a403bfd7a93a3001ef22ef02e3f", hex.EncodeToString(ntowfv2("Domain", "User", "Password")))
+}
+
+func TestHandshake(t *testing.T) {
+	challenge := ntlmtest.Challenge([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	authenticate, err := Authenticate(challenge, "", `CORP\alice`, "secret")
+	assert.NoError(t, err)
+
+	domain, username, err := ntlmtest.Verify(challenge, authenticate, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "CORP", domain)
+	assert.Equal(t, "alice", username)
+
+	_, _, err = ntlmtest.Verify(challenge, authenticate, "wrong")
+	assert.Error(t, err)
+
+	_, err = Authenticate([]byte("garbage"), "", "alice", "secret")
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+}
+
+func TestNegotiate(t *testing.T) {
+	message := Negotiate()
+	assert.Len(t, message, 32)
+	assert.Equal(t, "NTLMSSP\x00", string(message[:8]))
+}
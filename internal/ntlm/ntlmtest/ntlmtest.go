@@ -0,0 +1,122 @@
+package ntlmtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4" //nolint:staticcheck // the nt hash is defined using md4
+)
+
+const (
+	challengeType    = 2
+	authenticateType = 3
+
+	// negotiateFlags are the flags offered by ntlm.Negotiate.
+	negotiateFlags = 0xa0888207
+
+	avEOL = 0
+
+	challengeHeaderLength    = 48
+	authenticateHeaderLength = 64
+)
+
+var (
+	signature = []byte("NTLMSSP\x00")
+
+	errInvalidMessage = errors.New("ntlmtest: invalid message")
+)
+
+// Challenge returns the second message of the handshake for the server challenge. Together with
+// Verify it implements the server side, which is useful to test clients.
+func Challenge(server [8]byte) []byte {
+	targetInfo := []byte{avEOL, 0, 0, 0}
+
+	message := binary.LittleEndian.AppendUint32(append([]byte{}, signature...), challengeType)
+	message = append(message, 0, 0, 0, 0, challengeHeaderLength, 0, 0, 0) // empty target name
+	message = binary.LittleEndian.AppendUint32(message, negotiateFlags)
+	message = append(message, server[:]...)
+	message = append(message, make([]byte, 8)...) // reserved
+	message = binary.LittleEndian.AppendUint16(message, uint16(len(targetInfo)))
+	message = binary.LittleEndian.AppendUint16(message, uint16(len(targetInfo)))
+	message = binary.LittleEndian.AppendUint32(message, challengeHeaderLength)
+
+	return append(message, targetInfo...)
+}
+
+// Verify checks the NTLMv2 response of the authenticate message against the password and returns
+// the domain and username of the client.
+func Verify(challengeMessage, authenticateMessage []byte, password string) (string, string, error) {
+	if !isMessage(challengeMessage, challengeType, challengeHeaderLength) ||
+		!isMessage(authenticateMessage, authenticateType, authenticateHeaderLength) {
+		return "", "", errInvalidMessage
+	}
+
+	ntResponse, ok1 := field(authenticateMessage, 20)
+	domain, ok2 := field(authenticateMessage, 28)
+	username, ok3 := field(authenticateMessage, 36)
+
+	if !ok1 || !ok2 || !ok3 || len(ntResponse) < 16 {
+		return "", "", errInvalidMessage
+	}
+
+	hash := md4.New()
+	hash.Write(encodeString(password))
+
+	key := hmacMD5(hash.Sum(nil), encodeString(strings.ToUpper(decodeString(username))+decodeString(domain)))
+
+	if !hmac.Equal(hmacMD5(key, challengeMessage[24:32], ntResponse[16:]), ntResponse[:16]) {
+		return "", "", errors.New("ntlmtest: wrong password")
+	}
+
+	return decodeString(domain), decodeString(username), nil
+}
+
+func isMessage(message []byte, messageType uint32, headerLength int) bool {
+	return len(message) >= headerLength &&
+		bytes.Equal(message[:8], signature) &&
+		binary.LittleEndian.Uint32(message[8:]) == messageType
+}
+
+// field reads the security buffer at offset, which references a slice of the payload.
+func field(message []byte, offset int) ([]byte, bool) {
+	length := int(binary.LittleEndian.Uint16(message[offset:]))
+	start := int(binary.LittleEndian.Uint32(message[offset+4:]))
+
+	if start+length > len(message) {
+		return nil, false
+	}
+
+	return message[start : start+length], true
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+func encodeString(s string) []byte {
+	var encoded []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		encoded = binary.LittleEndian.AppendUint16(encoded, r)
+	}
+
+	return encoded
+}
+
+func decodeString(encoded []byte) string {
+	decoded := make([]uint16, len(encoded)/2)
+	for i := range decoded {
+		decoded[i] = binary.LittleEndian.Uint16(encoded[i*2:])
+	}
+
+	return string(utf16.Decode(decoded))
+}
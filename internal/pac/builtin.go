@@ -1,6 +1,7 @@
 package pac
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"log/slog"
@@ -9,8 +10,14 @@ import (
 
 	"github.com/dop251/goja"
 	"github.com/gobwas/glob"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
 )
 
+func init() {
+	config.Define("pac.timeout.dns", config.Duration, "2s")
+}
+
 func declareBuiltins(vm *goja.Runtime) error {
 	for name, fn := range map[string]any{
 		"isPlainHostName":     isPlainHostName,
@@ -23,6 +30,15 @@ func declareBuiltins(vm *goja.Runtime) error {
 		"myIpAddress":         myIpAddress,
 		"dnsDomainLevels":     dnsDomainLevels,
 		"shExpMatch":          shExpMatch,
+		"isResolvableEx":      isResolvableEx,
+		"isInNetEx":           isInNetEx,
+		"dnsResolveEx":        dnsResolveEx,
+		"myIpAddressEx":       myIpAddressEx,
+		"sortIpAddressList":   sortIpAddressList,
+		"getClientVersion":    getClientVersion,
+		"weekdayRange":        weekdayRange,
+		"dateRange":           dateRange,
+		"timeRange":           timeRange,
 		"alert":               alert,
 	} {
 		if err := declareFunction(vm, name, fn); err != nil {
@@ -30,12 +46,6 @@ func declareBuiltins(vm *goja.Runtime) error {
 		}
 	}
 
-	// TODO implement time based conditions
-	//
-	//  - weekdayRange
-	//  - dateRange
-	//  - timeRange
-
 	return nil
 }
 
@@ -102,7 +112,7 @@ func isResolvable(host string) bool {
 
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#isinnet
 func isInNet(host, pattern, mask string) bool {
-	addr, err := net.ResolveIPAddr("ip4", host)
+	addr, err := lookupIPAddr("ip4", host)
 	if err != nil {
 		return false
 	}
@@ -117,7 +127,7 @@ func isInNet(host, pattern, mask string) bool {
 
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#dnsresolve
 func dnsResolve(host string) string {
-	ip, err := net.ResolveIPAddr("ip4", host)
+	ip, err := lookupIPAddr("ip4", host)
 	if err != nil {
 		return ""
 	}
@@ -136,7 +146,7 @@ func convertAddr(ip string) uint32 {
 
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#myipaddress
 func myIpAddress() string {
-	return "127.0.0.1"
+	return loadLocalAddress()
 }
 
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#dnsdomainlevels
@@ -158,3 +168,25 @@ func shExpMatch(str, shExp string) bool {
 func alert(message string) {
 	slog.Info("pac alert", slog.String("message", message))
 }
+
+// lookupIPAddr resolves the first address of host, but gives up after the configured dns timeout, so
+// a hanging dns server does not block the pac evaluation.
+func lookupIPAddr(network, host string) (*net.IPAddr, error) {
+	ctx, cancel := dnsContext()
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.IPAddr{IP: ips[0]}, nil
+}
+
+func dnsContext() (context.Context, context.CancelFunc) {
+	if timeout := loadSettings().dnsTimeout; timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
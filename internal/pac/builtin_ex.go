@@ -0,0 +1,134 @@
+package pac
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// The Microsoft extensions for IPv6 support in pac files.
+// See https://learn.microsoft.com/en-us/windows/win32/winhttp/ipv6-extensions-to-navigator-auto-config-file-format
+
+const (
+	clientVersion = "1.0"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/isresolvableex
+func isResolvableEx(host string) bool {
+	return dnsResolveEx(host) != ""
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/isinnetex
+func isInNetEx(host, prefix string) bool {
+	network, err := netip.ParsePrefix(strings.TrimSpace(prefix))
+	if err != nil {
+		return false
+	}
+
+	for _, addr := range resolveAll(host) {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/dnsresolveex
+func dnsResolveEx(host string) string {
+	return joinAddrs(resolveAll(host))
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/myipaddressex
+func myIpAddressEx() string {
+	if localAddressOverride.Load() {
+		return loadLocalAddress()
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	var addrs []netip.Addr
+	for _, ifaceAddr := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(ifaceAddr.String())
+		if err != nil {
+			continue
+		}
+
+		addr := prefix.Addr()
+		if addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+			continue
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return joinAddrs(addrs)
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/sortipaddresslist
+func sortIpAddressList(list string) (string, error) {
+	var addrs []netip.Addr
+
+	for field := range strings.SplitSeq(list, ";") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(field))
+		if err != nil {
+			return "", fmt.Errorf("invalid ip address list %q: %w", list, err)
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	slices.SortFunc(addrs, func(a, b netip.Addr) int {
+		// IPv6 addresses come before IPv4 addresses
+		if a.Is4() != b.Is4() {
+			if a.Is4() {
+				return 1
+			}
+
+			return -1
+		}
+
+		return a.Compare(b)
+	})
+
+	return joinAddrs(addrs), nil
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/winhttp/getclientversion
+func getClientVersion() string {
+	return clientVersion
+}
+
+func resolveAll(host string) []netip.Addr {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}
+	}
+
+	ctx, cancel := dnsContext()
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+
+	for i, ip := range ips {
+		ips[i] = ip.Unmap()
+	}
+
+	return ips
+}
+
+func joinAddrs(addrs []netip.Addr) string {
+	fields := make([]string, len(addrs))
+	for i, addr := range addrs {
+		fields[i] = addr.String()
+	}
+
+	return strings.Join(fields, ";")
+}
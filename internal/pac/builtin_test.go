@@ -1,8 +1,10 @@
 package pac
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,6 +51,35 @@ func TestConvertAddr(t *testing.T) {
 
 func TestMyIpAddress(t *testing.T) {
 	assert.Equal(t, "127.0.0.1", myIpAddress())
+
+	addr, err := detectLocalAddress("127.0.0.1:53")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", addr)
+
+	assert.Equal(t, "pac.example.org:80", probeAddress("http://pac.example.org/proxy.pac"))
+	assert.Equal(t, "pac.example.org:443", probeAddress("https://pac.example.org/proxy.pac"))
+	assert.Equal(t, "pac.example.org:8443", probeAddress("https://pac.example.org:8443/proxy.pac"))
+	assert.Equal(t, "8.8.8.8:53", probeAddress("file:///etc/proxy.pac"))
+
+	// the probe of a pac url without port must be dialable using udp
+	addr, err = detectLocalAddress(probeAddress("http://127.0.0.1/x.pac"))
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", addr)
+}
+
+func TestConfigureLocalAddress(t *testing.T) {
+	defer viper.Set("pac.myip.address", "")
+
+	viper.Set("pac.myip.address", "192.0.2.10")
+	configureLocalAddress("http://127.0.0.1/x.pac")
+	assert.Equal(t, "192.0.2.10", myIpAddress())
+	assert.Equal(t, "192.0.2.10", myIpAddressEx())
+
+	// removing the address detects it again
+	viper.Set("pac.myip.address", "")
+	configureLocalAddress("http://127.0.0.1/x.pac")
+	assert.Equal(t, "127.0.0.1", myIpAddress())
+	assert.NotEqual(t, "192.0.2.10", myIpAddressEx())
 }
 
 func TestDnsDomainLevels(t *testing.T) {
@@ -61,3 +92,39 @@ func TestShExpMatch(t *testing.T) {
 	assert.True(t, shExpMatch("http://home.netscape.com/people/ari/index.html", "*/ari/*"))
 	assert.False(t, shExpMatch("http://home.netscape.com/people/montulli/index.html", "*/ari/*"))
 }
+
+func TestIsResolvableEx(t *testing.T) {
+	assert.True(t, isResolvableEx("localhost"))
+	assert.True(t, isResolvableEx("::1"))
+	assert.False(t, isResolvableEx(""))
+}
+
+func TestIsInNetEx(t *testing.T) {
+	assert.True(t, isInNetEx("198.95.249.79", "198.95.249.79/32"))
+	assert.True(t, isInNetEx("198.95.249.79", "198.95.0.0/16"))
+	assert.False(t, isInNetEx("198.96.249.79", "198.95.0.0/16"))
+	assert.True(t, isInNetEx("3ffe:8311:ffff:abcd::1", "3ffe:8311:ffff::/48"))
+	assert.False(t, isInNetEx("3ffe:8312:ffff:abcd::1", "3ffe:8311:ffff::/48"))
+
+	assert.False(t, isInNetEx("198.95.249.79", "198.95.249.79"))
+	assert.False(t, isInNetEx("", ""))
+}
+
+func TestDnsResolveEx(t *testing.T) {
+	assert.Contains(t, strings.Split(dnsResolveEx("localhost"), ";"), "127.0.0.1")
+	assert.Equal(t, "::1", dnsResolveEx("::1"))
+	assert.Equal(t, "", dnsResolveEx(""))
+}
+
+func TestSortIpAddressList(t *testing.T) {
+	sorted, err := sortIpAddressList("10.2.3.9;2001:4898:28:3:201:2ff:feea:fc14;3ffe:8311:ffff:1:0:0:0:80;10.2.3.4")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:4898:28:3:201:2ff:feea:fc14;3ffe:8311:ffff:1::80;10.2.3.4;10.2.3.9", sorted)
+
+	_, err = sortIpAddressList("10.2.3.9;not-an-ip")
+	assert.Error(t, err)
+}
+
+func TestGetClientVersion(t *testing.T) {
+	assert.Equal(t, "1.0", getClientVersion())
+}
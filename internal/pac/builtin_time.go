@@ -0,0 +1,242 @@
+package pac
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// clock returns the current time used by the time based builtins. It is a variable so tests can
+	// replace it.
+	clock = time.Now
+
+	weekdays = map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}
+
+	months = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+)
+
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#weekdayrange
+func weekdayRange(args ...any) bool {
+	return weekdayRangeAt(clock(), args...)
+}
+
+func weekdayRangeAt(t time.Time, args ...any) bool {
+	t, args = applyGMT(t, args)
+
+	if len(args) < 1 || len(args) > 2 {
+		return false
+	}
+
+	wd1, ok := weekdays[argString(args[0])]
+	if !ok {
+		return false
+	}
+
+	wd2 := wd1
+	if len(args) == 2 {
+		if wd2, ok = weekdays[argString(args[1])]; !ok {
+			return false
+		}
+	}
+
+	return inRange(int(t.Weekday()), wd1, wd2)
+}
+
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#daterange
+func dateRange(args ...any) bool {
+	return dateRangeAt(clock(), args...)
+}
+
+func dateRangeAt(t time.Time, args ...any) bool {
+	t, args = applyGMT(t, args)
+
+	// A day and a month or a month and a year denote a single date, while two values of the same
+	// kind are a range.
+	if single, ok := parseDate(args); ok && len(args) > 0 && len(args) <= 3 {
+		return single == projectDate(t, single)
+	}
+
+	switch len(args) {
+	case 2, 4, 6:
+		half := len(args) / 2
+
+		start, ok := parseDate(args[:half])
+		if !ok {
+			return false
+		}
+
+		end, ok := parseDate(args[half:])
+		if !ok || !start.sameFields(end) {
+			return false
+		}
+
+		current := projectDate(t, start)
+		return inRange(current.key(), start.key(), end.key())
+
+	default:
+		return false
+	}
+}
+
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Proxy_servers_and_tunneling/Proxy_Auto-Configuration_PAC_file#timerange
+func timeRange(args ...any) bool {
+	return timeRangeAt(clock(), args...)
+}
+
+func timeRangeAt(t time.Time, args ...any) bool {
+	t, args = applyGMT(t, args)
+
+	values := make([]int, len(args))
+	for i, arg := range args {
+		value, ok := argInt(arg)
+		if !ok {
+			return false
+		}
+
+		values[i] = value
+	}
+
+	current := t.Hour()*3600 + t.Minute()*60 + t.Second()
+
+	switch len(values) {
+	case 1:
+		return t.Hour() == values[0]
+
+	case 2:
+		return inRange(t.Hour(), values[0], values[1])
+
+	case 4:
+		start := values[0]*3600 + values[1]*60
+		end := values[2]*3600 + values[3]*60 + 59
+		return inRange(current, start, end)
+
+	case 6:
+		start := values[0]*3600 + values[1]*60 + values[2]
+		end := values[3]*3600 + values[4]*60 + values[5]
+		return inRange(current, start, end)
+
+	default:
+		return false
+	}
+}
+
+// applyGMT strips an optional trailing "GMT" argument and converts t to UTC if it was present.
+func applyGMT(t time.Time, args []any) (time.Time, []any) {
+	if len(args) > 0 && argString(args[len(args)-1]) == "GMT" {
+		return t.UTC(), args[:len(args)-1]
+	}
+
+	return t, args
+}
+
+// inRange checks if value is within the inclusive range [start, end]. If start is greater than
+// end, the range wraps around.
+func inRange(value, start, end int) bool {
+	if start <= end {
+		return start <= value && value <= end
+	}
+
+	return value >= start || value <= end
+}
+
+// date is a partial calendar date. Fields that were not specified are zero.
+type date struct {
+	year, month, day int
+}
+
+func parseDate(args []any) (date, bool) {
+	var d date
+
+	for _, arg := range args {
+		if month, ok := months[argString(arg)]; ok {
+			if d.month != 0 {
+				return d, false
+			}
+
+			d.month = month
+			continue
+		}
+
+		value, ok := argInt(arg)
+		switch {
+		case !ok || value < 1:
+			return d, false
+
+		case value < 32:
+			if d.day != 0 {
+				return d, false
+			}
+
+			d.day = value
+
+		default:
+			if d.year != 0 {
+				return d, false
+			}
+
+			d.year = value
+		}
+	}
+
+	return d, true
+}
+
+// projectDate returns the date of t, reduced to the fields present in pattern.
+func projectDate(t time.Time, pattern date) date {
+	var d date
+
+	if pattern.year != 0 {
+		d.year = t.Year()
+	}
+
+	if pattern.month != 0 {
+		d.month = int(t.Month())
+	}
+
+	if pattern.day != 0 {
+		d.day = t.Day()
+	}
+
+	return d
+}
+
+func (d date) sameFields(other date) bool {
+	return (d.year == 0) == (other.year == 0) &&
+		(d.month == 0) == (other.month == 0) &&
+		(d.day == 0) == (other.day == 0)
+}
+
+func (d date) key() int {
+	return d.year*10000 + d.month*100 + d.day
+}
+
+func argString(arg any) string {
+	if s, ok := arg.(string); ok {
+		return strings.ToUpper(strings.TrimSpace(s))
+	}
+
+	return ""
+}
+
+func argInt(arg any) (int, bool) {
+	switch v := arg.(type) {
+	case int64:
+		return int(v), true
+
+	case float64:
+		return int(v), v == float64(int(v))
+
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		return i, err == nil
+
+	default:
+		return 0, false
+	}
+}
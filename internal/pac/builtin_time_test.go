@@ -0,0 +1,119 @@
+package pac
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	// Thursday, 2025-04-17 01:30:15 in UTC+2, which is still Wednesday in UTC
+	testTime = time.Date(2025, time.April, 17, 1, 30, 15, 0, time.FixedZone("UTC+2", 2*60*60))
+)
+
+func TestWeekdayRange(t *testing.T) {
+	for _, tc := range []struct {
+		args     []any
+		expected bool
+	}{
+		{args: []any{"THU"}, expected: true},
+		{args: []any{"FRI"}, expected: false},
+		{args: []any{"MON", "FRI"}, expected: true},
+		{args: []any{"FRI", "SUN"}, expected: false},
+		{args: []any{"SAT", "THU"}, expected: true},
+		{args: []any{"FRI", "WED"}, expected: false},
+		{args: []any{"WED", "GMT"}, expected: true},
+		{args: []any{"THU", "GMT"}, expected: false},
+		{args: []any{"XYZ"}, expected: false},
+		{args: []any{}, expected: false},
+	} {
+		assert.Equal(t, tc.expected, weekdayRangeAt(testTime, tc.args...), "%v", tc.args)
+	}
+}
+
+func TestDateRange(t *testing.T) {
+	for _, tc := range []struct {
+		args     []any
+		expected bool
+	}{
+		{args: []any{int64(17)}, expected: true},
+		{args: []any{int64(16)}, expected: false},
+		{args: []any{int64(16), "GMT"}, expected: true},
+		{args: []any{"APR"}, expected: true},
+		{args: []any{"MAY"}, expected: false},
+		{args: []any{int64(2025)}, expected: true},
+		{args: []any{int64(2024)}, expected: false},
+		{args: []any{int64(1), int64(16)}, expected: false},
+		{args: []any{int64(1), int64(17)}, expected: true},
+		{args: []any{int64(20), int64(17)}, expected: true},
+		{args: []any{int64(20), int64(16)}, expected: false},
+		{args: []any{"JAN", "MAR"}, expected: false},
+		{args: []any{"NOV", "APR"}, expected: true},
+		{args: []any{int64(2020), int64(2030)}, expected: true},
+		{args: []any{int64(1), "APR", int64(16), "APR"}, expected: false},
+		{args: []any{int64(1), "APR", int64(17), "APR"}, expected: true},
+		{args: []any{int64(24), "DEC", int64(20), "APR"}, expected: true},
+		{args: []any{"MAR", int64(2025), "MAY", int64(2025)}, expected: true},
+		{args: []any{"MAR", int64(2024), "MAR", int64(2025)}, expected: false},
+		{args: []any{int64(1), "JAN", int64(2025), int64(17), "APR", int64(2025)}, expected: true},
+		{args: []any{int64(1), "JAN", int64(2025), int64(16), "APR", int64(2025)}, expected: false},
+		{args: []any{int64(17), "APR"}, expected: true},
+		{args: []any{int64(1), "JAN"}, expected: false},
+		{args: []any{int64(24), "DEC"}, expected: false},
+		{args: []any{"APR", int64(2025)}, expected: true},
+		{args: []any{"APR", int64(2024)}, expected: false},
+		{args: []any{int64(17), "APR", int64(2025)}, expected: true},
+		{args: []any{int64(17), "APR", "GMT"}, expected: false},
+		{args: []any{int64(1), int64(2), int64(3)}, expected: false},
+		{args: []any{}, expected: false},
+	} {
+		assert.Equal(t, tc.expected, dateRangeAt(testTime, tc.args...), "%v", tc.args)
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	for _, tc := range []struct {
+		args     []any
+		expected bool
+	}{
+		{args: []any{int64(1)}, expected: true},
+		{args: []any{int64(23), "GMT"}, expected: true},
+		{args: []any{int64(0)}, expected: false},
+		{args: []any{int64(8), int64(17)}, expected: false},
+		{args: []any{int64(22), int64(6)}, expected: true},
+		{args: []any{int64(22), int64(23), "GMT"}, expected: true},
+		{args: []any{int64(1), int64(0), int64(1), int64(30)}, expected: true},
+		{args: []any{int64(1), int64(31), int64(1), int64(59)}, expected: false},
+		{args: []any{int64(1), int64(45), int64(0), int64(15)}, expected: false},
+		{args: []any{int64(23), int64(15), int64(2), int64(0)}, expected: true},
+		{args: []any{int64(1), int64(30), int64(0), int64(1), int64(30), int64(15)}, expected: true},
+		{args: []any{int64(1), int64(30), int64(16), int64(1), int64(31), int64(0)}, expected: false},
+		{args: []any{"1"}, expected: true},
+		{args: []any{int64(1), int64(2), int64(3)}, expected: false},
+		{args: []any{}, expected: false},
+	} {
+		assert.Equal(t, tc.expected, timeRangeAt(testTime, tc.args...), "%v", tc.args)
+	}
+}
+
+func TestTimeBuiltinsFromScript(t *testing.T) {
+	defer func(original func() time.Time) { clock = original }(clock)
+	clock = func() time.Time { return testTime }
+
+	config, err := FromSource([]byte(`
+		function FindProxyForURL(url, host) {
+			if (weekdayRange("MON", "FRI") && timeRange(22, 6) && dateRange(1, "APR", 30, "APR")) {
+				return "PROXY night:8080";
+			}
+
+			return "DIRECT";
+		}
+	`))
+	assert.NoError(t, err)
+
+	proxies, err := config.Resolve(&url.URL{Scheme: "https", Host: "example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*url.URL{{Scheme: "proxy", Host: "night:8080"}}, proxies)
+}
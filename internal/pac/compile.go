@@ -1,28 +1,145 @@
 package pac
 
-import "github.com/dop251/goja"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+)
 
 const (
-	resolveFuncName = "FindProxyForURL"
+	resolveFuncName   = "FindProxyForURL"
+	resolveExFuncName = "FindProxyForURLEx"
 )
 
-type resolveFunc func(url, host string) *string
+var (
+	// ErrEvaluationAborted is returned, if the pac did not finish within the configured timeout.
+	ErrEvaluationAborted = errors.New("pac evaluation aborted")
+)
+
+type resolveFunc func(url, host string) (*string, error)
+
+// script is a compiled pac file. The goja.Runtime is not goroutine-safe, so the pac file is compiled
+// into a pool of independent runtimes, that can be used in parallel.
+// See https://github.com/dop251/goja?tab=readme-ov-file#is-it-goroutine-safe
+type script struct {
+	pool chan resolveFunc
+}
+
+func compileScript(source []byte, size int, timeout time.Duration) (*script, error) {
+	s := script{
+		pool: make(chan resolveFunc, size),
+	}
+
+	for range size {
+		resolve, err := compile(source, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		s.pool <- resolve
+	}
+
+	return &s, nil
+}
+
+func (s *script) resolve(url, host string) (*string, error) {
+	resolve := <-s.pool
+	defer func() { s.pool <- resolve }()
+
+	return resolve(url, host)
+}
 
-func compile(source []byte) (resolveFunc, error) {
+// compile runs the pac in a new runtime and returns its entrypoint. Each call of the entrypoint is
+// interrupted, if it does not return within the timeout. A timeout of zero disables the deadline.
+func compile(source []byte, timeout time.Duration) (resolveFunc, error) {
 	vm := goja.New()
 
 	if err := declareBuiltins(vm); err != nil {
 		return nil, err
 	}
 
-	if _, err := vm.RunString(string(source)); err != nil {
+	if _, err := callWithTimeout(vm, timeout, func() (goja.Value, error) {
+		return vm.RunString(string(source))
+	}); err != nil {
 		return nil, err
 	}
 
-	var resolve resolveFunc
-	if err := vm.ExportTo(vm.Get(resolveFuncName), &resolve); err != nil {
+	fn, err := lookupResolveFunc(vm)
+	if err != nil {
 		return nil, err
 	}
 
+	call, ok := goja.AssertFunction(fn)
+	if !ok {
+		return nil, fmt.Errorf("pac entrypoint is not a function")
+	}
+
+	resolve := func(url, host string) (*string, error) {
+		result, err := callWithTimeout(vm, timeout, func() (goja.Value, error) {
+			return call(goja.Undefined(), vm.ToValue(url), vm.ToValue(host))
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if goja.IsUndefined(result) || goja.IsNull(result) {
+			return nil, nil
+		}
+
+		target := result.String()
+		return &target, nil
+	}
+
 	return resolve, nil
 }
+
+func callWithTimeout(vm *goja.Runtime, timeout time.Duration, fn func() (goja.Value, error)) (goja.Value, error) {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	var (
+		mu   sync.Mutex
+		done bool
+	)
+
+	timer := time.AfterFunc(timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !done {
+			vm.Interrupt(ErrEvaluationAborted)
+		}
+	})
+
+	result, err := fn()
+
+	mu.Lock()
+	done = true
+	mu.Unlock()
+
+	timer.Stop()
+	vm.ClearInterrupt()
+
+	var interrupted *goja.InterruptedError
+	if errors.As(err, &interrupted) {
+		return nil, fmt.Errorf("%w: no result after %s", ErrEvaluationAborted, timeout)
+	}
+
+	return result, err
+}
+
+// lookupResolveFunc prefers the Microsoft IPv6 aware entrypoint, if the script defines it.
+func lookupResolveFunc(vm *goja.Runtime) (goja.Value, error) {
+	for _, name := range []string{resolveExFuncName, resolveFuncName} {
+		if fn := vm.Get(name); fn != nil && !goja.IsUndefined(fn) {
+			return fn, nil
+		}
+	}
+
+	return nil, fmt.Errorf("pac does not define %s or %s", resolveFuncName, resolveExFuncName)
+}
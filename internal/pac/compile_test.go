@@ -0,0 +1,59 @@
+package pac
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompilePrefersResolveEx(t *testing.T) {
+	resolve, err := compile([]byte(`
+		function FindProxyForURL(url, host) {
+			return "PROXY ipv4:8080";
+		}
+
+		function FindProxyForURLEx(url, host) {
+			if (isInNetEx(host, "2001:db8::/32")) {
+				return "PROXY ipv6:8080";
+			}
+
+			return "DIRECT";
+		}
+	`), 0)
+	assert.NoError(t, err)
+
+	target, err := resolve("http://[2001:db8::1]/", "2001:db8::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "PROXY ipv6:8080", *target)
+
+	target, err = resolve("http://192.0.2.1/", "192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "DIRECT", *target)
+}
+
+func TestCompileWithoutResolveFunc(t *testing.T) {
+	_, err := compile([]byte(`var x = 1;`), 0)
+	assert.Error(t, err)
+}
+
+func TestCompileTimeout(t *testing.T) {
+	_, err := compile([]byte(`while (true) {}`), 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrEvaluationAborted)
+
+	resolve, err := compile([]byte(`
+		function FindProxyForURL(url, host) {
+			while (host == "loop") {}
+			return "DIRECT";
+		}
+	`), 50*time.Millisecond)
+	assert.NoError(t, err)
+
+	_, err = resolve("http://loop/", "loop")
+	assert.ErrorIs(t, err, ErrEvaluationAborted)
+
+	// the runtime is usable after an interrupt
+	target, err := resolve("http://example.org/", "example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, "DIRECT", *target)
+}
@@ -0,0 +1,103 @@
+package pac
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+const generatedHeader = "// Generated by https://github.com/lukasdietrich/proxyproxy\n\n"
+
+// Generate returns a pac, that sends every url through proxy, except for hosts matching an entry of
+// noProxy.
+func Generate(proxy string, noProxy []string) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(generatedHeader)
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	writeNoProxy(&b, "  ", noProxy)
+	fmt.Fprintf(&b, "  return %q;\n", "PROXY "+proxy)
+	b.WriteString("}\n")
+
+	return b.Bytes()
+}
+
+// Rewrite wraps the source of a pac, so that every url the pac does not connect to directly is sent
+// through proxy instead. Hosts matching an entry of noProxy are always connected to directly. Like
+// the evaluation of the pac, FindProxyForURLEx is preferred over FindProxyForURL.
+func Rewrite(source []byte, proxy string, noProxy []string) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(generatedHeader)
+	b.WriteString("var FindProxyForURL = (function () {\n")
+	b.Write(source)
+	b.WriteString("\n\n")
+	b.WriteString("  var upstream = typeof FindProxyForURLEx == \"function\" ? FindProxyForURLEx : FindProxyForURL;\n\n")
+	b.WriteString("  return function (url, host) {\n")
+	writeNoProxy(&b, "    ", noProxy)
+	b.WriteString("    if (/^\\s*DIRECT\\s*(;|$)/i.test(upstream(url, host))) {\n")
+	b.WriteString("      return \"DIRECT\";\n")
+	b.WriteString("    }\n\n")
+	fmt.Fprintf(&b, "    return %q;\n", "PROXY "+proxy)
+	b.WriteString("  };\n")
+	b.WriteString("})();\n")
+
+	return b.Bytes()
+}
+
+// writeNoProxy writes a condition returning DIRECT for hosts matching the entries, which follow the
+// conventions of the no_proxy environment variable.
+func writeNoProxy(b *bytes.Buffer, indent string, noProxy []string) {
+	var conditions []string
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+
+		if condition := noProxyCondition(entry); condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return
+	}
+
+	fmt.Fprintf(b, "%sif (%s) {\n", indent, strings.Join(conditions, " ||\n"+indent+"    "))
+	fmt.Fprintf(b, "%s  return \"DIRECT\";\n", indent)
+	fmt.Fprintf(b, "%s}\n\n", indent)
+}
+
+func noProxyCondition(entry string) string {
+	if entry == "" {
+		return ""
+	}
+
+	if entry == "*" {
+		return "true"
+	}
+
+	// Networks only apply to ip addresses, so the host is never resolved.
+	if prefix, err := netip.ParsePrefix(entry); err == nil {
+		prefix = prefix.Masked()
+
+		if prefix.Addr().Is4() {
+			mask := net.IP(net.CIDRMask(prefix.Bits(), 32))
+			return fmt.Sprintf("(/^[0-9.]+$/.test(host) && isInNet(host, %q, %q))", prefix.Addr(), mask)
+		}
+
+		return fmt.Sprintf("(host.indexOf(\":\") >= 0 && typeof isInNetEx == \"function\" && isInNetEx(host, %q))", prefix)
+	}
+
+	if addr, err := netip.ParseAddr(strings.Trim(entry, "[]")); err == nil {
+		if addr.Is6() {
+			return fmt.Sprintf("host == %q || host == %q", addr, "["+addr.String()+"]")
+		}
+
+		return fmt.Sprintf("host == %q", addr)
+	}
+
+	domain := strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+	return fmt.Sprintf("host == %q || dnsDomainIs(host, %q)", domain, "."+domain)
+}
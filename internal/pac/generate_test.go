@@ -0,0 +1,80 @@
+package pac
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	noProxy := []string{"localhost", ".internal.org", "*.corp.org", "10.0.0.0/8", "fd00::/8", "::1", ""}
+
+	generated, err := FromSource(Generate("proxyproxy:8080", noProxy))
+	assert.NoError(t, err)
+
+	rewritten, err := FromSource(Rewrite([]byte(`
+		function FindProxyForURL(url, host) {
+			if (host == "direct.org") {
+				return "DIRECT";
+			}
+
+			return "PROXY corporate:8080; DIRECT";
+		}
+	`), "proxyproxy:8080", noProxy))
+	assert.NoError(t, err)
+
+	rewrittenEx, err := FromSource(Rewrite([]byte(`
+		function FindProxyForURLEx(url, host) {
+			return "DIRECT";
+		}
+	`), "proxyproxy:8080", nil))
+	assert.NoError(t, err)
+
+	// the ex variant is preferred, like by the evaluation of the original pac
+	both := []byte(`
+		function FindProxyForURL(url, host) {
+			return "PROXY corporate:8080";
+		}
+
+		function FindProxyForURLEx(url, host) {
+			return "DIRECT";
+		}
+	`)
+
+	original, err := FromSource(both)
+	assert.NoError(t, err)
+
+	rewrittenBoth, err := FromSource(Rewrite(both, "proxyproxy:8080", nil))
+	assert.NoError(t, err)
+
+	proxyproxy := []*url.URL{{Scheme: "proxy", Host: "proxyproxy:8080"}}
+	direct := []*url.URL{nil}
+
+	for _, tc := range []struct {
+		config   *Config
+		host     string
+		expected []*url.URL
+	}{
+		{config: generated, host: "example.org", expected: proxyproxy},
+		{config: generated, host: "localhost", expected: direct},
+		{config: generated, host: "internal.org", expected: direct},
+		{config: generated, host: "www.internal.org", expected: direct},
+		{config: generated, host: "www.corp.org", expected: direct},
+		{config: generated, host: "notcorp.org", expected: proxyproxy},
+		{config: generated, host: "10.1.2.3", expected: direct},
+		{config: generated, host: "11.1.2.3", expected: proxyproxy},
+		{config: generated, host: "[::1]", expected: direct},
+		{config: generated, host: "[fd00::1]", expected: direct},
+		{config: rewritten, host: "example.org", expected: proxyproxy},
+		{config: rewritten, host: "direct.org", expected: direct},
+		{config: rewritten, host: "www.internal.org", expected: direct},
+		{config: rewrittenEx, host: "example.org", expected: direct},
+		{config: original, host: "example.org", expected: direct},
+		{config: rewrittenBoth, host: "example.org", expected: direct},
+	} {
+		proxies, err := tc.config.Resolve(&url.URL{Scheme: "https", Host: tc.host})
+		assert.NoError(t, err, tc.host)
+		assert.Equal(t, tc.expected, proxies, tc.host)
+	}
+}
@@ -0,0 +1,150 @@
+package pac
+
+import (
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("pac.myip.address", config.String, "")
+	config.Define("pac.myip.probe", config.String, "8.8.8.8:53")
+	config.Define("pac.myip.interval", config.Duration, "30s")
+}
+
+const (
+	fallbackLocalAddress = "127.0.0.1"
+)
+
+var (
+	// localAddress is the address returned by myIpAddress.
+	localAddress atomic.Pointer[string]
+	// localAddressOverride is set, if the address is configured explicitly.
+	localAddressOverride atomic.Bool
+	// localProbe is the address used to detect the local address.
+	localProbe atomic.Pointer[string]
+	// watching starts watching the interfaces once, even if the pac url changes.
+	watching sync.Once
+)
+
+func loadLocalAddress() string {
+	if addr := localAddress.Load(); addr != nil {
+		return *addr
+	}
+
+	return fallbackLocalAddress
+}
+
+// configureLocalAddress sets up the address returned by myIpAddress. Unless an address is configured
+// explicitly, it is the address of the interface used to reach the pac host (or the probe address
+// for local pac files) and is re-evaluated whenever the interfaces change.
+func configureLocalAddress(pacUrl string) {
+	if addr := viper.GetString("pac.myip.address"); addr != "" {
+		slog.Info("using configured local address", slog.String("addr", addr))
+
+		localAddress.Store(&addr)
+		localAddressOverride.Store(true)
+		return
+	}
+
+	probe := probeAddress(pacUrl)
+	interval := viper.GetDuration("pac.myip.interval")
+
+	localAddressOverride.Store(false)
+	localProbe.Store(&probe)
+	updateLocalAddress(probe)
+	watching.Do(func() { go watchInterfaces(interval) })
+}
+
+func probeAddress(pacUrl string) string {
+	u, err := url.Parse(pacUrl)
+	if err != nil || u.Hostname() == "" {
+		return viper.GetString("pac.myip.probe")
+	}
+
+	port := u.Port()
+	if port == "" {
+		// /etc/services lists most schemes for tcp only, so the name cannot be dialed using udp
+		number, err := net.LookupPort("tcp", u.Scheme)
+		if err != nil {
+			return viper.GetString("pac.myip.probe")
+		}
+
+		port = strconv.Itoa(number)
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func watchInterfaces(interval time.Duration) {
+	known := interfaceFingerprint()
+
+	for range time.NewTicker(interval).C {
+		if localAddressOverride.Load() {
+			continue
+		}
+
+		if current := interfaceFingerprint(); current != known {
+			slog.Debug("network interfaces changed")
+
+			known = current
+			updateLocalAddress(*localProbe.Load())
+		}
+	}
+}
+
+func updateLocalAddress(probe string) {
+	addr, err := detectLocalAddress(probe)
+	if err != nil {
+		slog.Warn("could not detect local address",
+			slog.String("probe", probe),
+			slog.String("fallback", fallbackLocalAddress),
+			slog.Any("err", err),
+		)
+
+		addr = fallbackLocalAddress
+	}
+
+	if previous := localAddress.Swap(&addr); previous == nil || *previous != addr {
+		slog.Info("detected local address", slog.String("addr", addr))
+	}
+}
+
+// detectLocalAddress returns the source address the os would choose to reach the probe address. No
+// packets are sent, since udp is connectionless.
+func detectLocalAddress(probe string) (string, error) {
+	conn, err := net.Dial("udp", probe)
+	if err != nil {
+		return "", err
+	}
+
+	//nolint:errcheck
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+func interfaceFingerprint() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+
+	fields := make([]string, len(addrs))
+	for i, addr := range addrs {
+		fields[i] = addr.String()
+	}
+
+	slices.Sort(fields)
+	return strings.Join(fields, ",")
+}
@@ -0,0 +1,134 @@
+package pac
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/match"
+)
+
+func init() {
+	config.Define("pac.overrides", config.Structure, "")
+	config.AddValidator(func() error {
+		_, err := overridesFromEnv()
+		return err
+	})
+}
+
+const (
+	// targetBlock is the override decision to refuse the request.
+	targetBlock = "BLOCK"
+)
+
+var (
+	// ErrBlocked is returned by Resolve, if an override rule blocks the url.
+	ErrBlocked = errors.New("blocked by override rule")
+)
+
+// overrideRule routes matching urls to target instead of asking the pac. Every configured criterion
+// has to match, while any entry of a criterion is sufficient.
+type overrideRule struct {
+	match.Destination `mapstructure:",squash"`
+
+	Name    string   `mapstructure:"name"`
+	Schemes []string `mapstructure:"schemes"`
+	// Target is a pac result like "PROXY host:port; DIRECT" or BLOCK.
+	Target string `mapstructure:"target"`
+}
+
+func (o *overrideRule) compile() error {
+	o.Target = strings.TrimSpace(o.Target)
+
+	if !strings.EqualFold(o.Target, targetBlock) {
+		for proxy, err := range parseTargetWithFallback(&o.Target) {
+			if err != nil {
+				return err
+			}
+
+			if proxy != nil && !isSupportedUpstreamProxy(proxy) {
+				return fmt.Errorf("unsupported upstream proxy %q", proxy.Scheme)
+			}
+		}
+	}
+
+	return o.Destination.Compile()
+}
+
+func (o *overrideRule) String() string {
+	if o.Name != "" {
+		return o.Name
+	}
+
+	return fmt.Sprintf("%s hosts=%v networks=%v schemes=%v ports=%v",
+		o.Target, o.Hosts, o.Networks, o.Schemes, o.Ports)
+}
+
+func (o *overrideRule) matches(requestUrl *url.URL) bool {
+	host := strings.ToLower(strings.TrimSuffix(requestUrl.Hostname(), "."))
+
+	if len(o.Schemes) > 0 && !o.matchesScheme(requestUrl.Scheme) {
+		return false
+	}
+
+	// hosts, that cannot be resolved, are not part of any network
+	matches, _ := o.Destination.Matches(host, urlPort(requestUrl), func() ([]netip.Addr, error) {
+		return resolveAll(host), nil
+	})
+
+	return matches
+}
+
+func (o *overrideRule) matchesScheme(scheme string) bool {
+	for _, s := range o.Schemes {
+		if strings.EqualFold(strings.TrimSpace(s), scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// urlPort returns the port of the url, defaulting to the port of the scheme.
+func urlPort(u *url.URL) int {
+	if port, err := strconv.Atoi(u.Port()); err == nil {
+		return port
+	}
+
+	if u.Scheme == "https" {
+		return 443
+	}
+
+	return 80
+}
+
+// overridesFromEnv reads the ordered override rules.
+func overridesFromEnv() ([]*overrideRule, error) {
+	var rules []*overrideRule
+	if err := config.UnmarshalKey("pac.overrides", &rules); err != nil {
+		return nil, fmt.Errorf("invalid pac.overrides: %w", err)
+	}
+
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("invalid pac.overrides[%d]: %w", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// override returns the target of the first matching rule.
+func override(rules []*overrideRule, requestUrl *url.URL) (*overrideRule, bool) {
+	for _, rule := range rules {
+		if rule.matches(requestUrl) {
+			return rule, true
+		}
+	}
+
+	return nil, false
+}
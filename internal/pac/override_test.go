@@ -0,0 +1,91 @@
+package pac
+
+import (
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveOverrides(t *testing.T) {
+	viper.Set("pac.overrides", `[
+		{"name": "staging", "hosts": "staging.example.org", "target": "DIRECT"},
+		{"hosts": "*.partner.org", "schemes": "https", "target": "SOCKS5 partner:1080; DIRECT"},
+		{"networks": "10.0.0.0/8", "ports": "22,8000-8999", "target": "BLOCK"}
+	]`)
+	defer viper.Set("pac.overrides", "")
+
+	overrides, err := overridesFromEnv()
+	assert.NoError(t, err)
+
+	config, err := FromSource([]byte(`function FindProxyForURL(url, host) { return "PROXY corporate:8080"; }`))
+	assert.NoError(t, err)
+	config.overrides.Store(&overrides)
+
+	for _, tc := range []struct {
+		url      string
+		expected []*url.URL
+		err      error
+	}{
+		{url: "http://STAGING.example.org.", expected: []*url.URL{nil}},
+		{url: "https://www.partner.org", expected: []*url.URL{{Scheme: "socks5", Host: "partner:1080"}, nil}},
+		{url: "http://www.partner.org", expected: []*url.URL{{Scheme: "proxy", Host: "corporate:8080"}}},
+		{url: "http://10.1.2.3:8080", err: ErrBlocked},
+		{url: "http://10.1.2.3:9000", expected: []*url.URL{{Scheme: "proxy", Host: "corporate:8080"}}},
+		{url: "http://10.1.2.3", expected: []*url.URL{{Scheme: "proxy", Host: "corporate:8080"}}},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			requestUrl, err := url.Parse(tc.url)
+			assert.NoError(t, err)
+
+			proxies, err := config.Resolve(requestUrl)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expected, proxies)
+		})
+	}
+}
+
+func TestOverridesInvalid(t *testing.T) {
+	defer viper.Set("pac.overrides", "")
+
+	for _, overrides := range []string{
+		`[{"target": "PROXY"}]`,
+		`[{"target": "FTP ftp:21"}]`,
+		`[{"ports": "8080-80", "target": "DIRECT"}]`,
+		`[{"networks": "10.0.0.0/33", "target": "DIRECT"}]`,
+		`[{"hosts": "[", "target": "DIRECT"}]`,
+	} {
+		viper.Set("pac.overrides", overrides)
+
+		_, err := overridesFromEnv()
+		assert.Error(t, err, overrides)
+	}
+}
+
+func TestReloadOverrides(t *testing.T) {
+	defer viper.Set("pac.overrides", "")
+
+	config, err := FromSource([]byte(`function FindProxyForURL(url, host) { return "DIRECT"; }`))
+	assert.NoError(t, err)
+
+	var changes atomic.Int32
+	config.OnChange(func() { changes.Add(1) })
+
+	requestUrl := &url.URL{Scheme: "https", Host: "www.example.org"}
+
+	viper.Set("pac.overrides", `[{"hosts": "*.example.org", "target": "BLOCK"}]`)
+	assert.NoError(t, config.ReloadOverrides())
+
+	_, err = config.Resolve(requestUrl)
+	assert.ErrorIs(t, err, ErrBlocked)
+
+	// invalid rules keep the previous ones
+	viper.Set("pac.overrides", `[{"target": "PROXY"}]`)
+	assert.Error(t, config.ReloadOverrides())
+
+	_, err = config.Resolve(requestUrl)
+	assert.ErrorIs(t, err, ErrBlocked)
+	assert.EqualValues(t, 1, changes.Load())
+}
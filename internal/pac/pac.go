@@ -1,103 +1,558 @@
 package pac
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"iter"
 	"log/slog"
 	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
 )
 
 func init() {
-	viper.SetDefault("pac.url", "")
+	config.Define("pac.url", config.String, "")
+	config.Define("pac.refresh.interval", config.Duration, "1h")
+	config.Define("pac.pool.size", config.Int, runtime.GOMAXPROCS(0))
+	config.Define("pac.timeout.evaluation", config.Duration, "5s")
+	config.Define("pac.fallback", config.String, fallbackFail)
+	config.AddValidator(func() error {
+		_, err := fallbackTarget(viper.GetString("pac.fallback"))
+		return err
+	})
 }
 
+const (
+	// fallbackFail is the fallback decision to fail requests, if the pac evaluation is aborted.
+	fallbackFail = "FAIL"
+	// sourceFallback is the source of decisions made by the fallback instead of the pac.
+	sourceFallback = "fallback"
+)
+
 var (
-	supportedUpstreamProxies = []string{"http", "https", "proxy"}
+	supportedUpstreamProxies = []string{"http", "https", "proxy", "socks", "socks4", "socks5"}
+
+	// currentSettings are used while resolving urls.
+	currentSettings atomic.Pointer[settings]
 )
 
+// settings are used while resolving urls. They are kept as a snapshot, because viper must not be
+// read while the config file is reloaded.
+type settings struct {
+	fallback   string
+	dnsTimeout time.Duration
+}
+
+// storeSettings snapshots the settings used while resolving urls.
+func storeSettings() {
+	currentSettings.Store(&settings{
+		fallback:   viper.GetString("pac.fallback"),
+		dnsTimeout: viper.GetDuration("pac.timeout.dns"),
+	})
+}
+
+// loadSettings returns the snapshot of the settings. Without a snapshot, aborted evaluations fail
+// and dns lookups have no deadline.
+func loadSettings() *settings {
+	if s := currentSettings.Load(); s != nil {
+		return s
+	}
+
+	return &settings{fallback: fallbackFail}
+}
+
 type Config struct {
-	mu      sync.Mutex
-	resolve resolveFunc
+	current atomic.Pointer[script]
+	// overrides take precedence over the pac.
+	overrides atomic.Pointer[[]*overrideRule]
+
+	// poolSize and evaluationTimeout are used to compile the pac. Changing them requires a restart.
+	poolSize          int
+	evaluationTimeout time.Duration
+
+	mu         sync.Mutex
+	url        string
+	document   *document
+	listeners  []func()
+	refreshing bool
 }
 
 func FromEnv() (*Config, error) {
-	url := viper.GetString("pac.url")
+	if _, err := fallbackTarget(viper.GetString("pac.fallback")); err != nil {
+		return nil, err
+	}
+
+	overrides, err := overridesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := urlFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
 	if url == "" {
 		slog.Info("no pac url provided. defaulting direct connections")
-		return Direct(), nil
+
+		config := Direct()
+		config.overrides.Store(&overrides)
+		return config, nil
 	}
 
 	slog.Info("configuring upstream proxies using pac", slog.String("url", url))
-	return FromUrl(url)
+	configureLocalAddress(url)
+
+	config, err := FromUrl(url)
+	if err != nil {
+		return nil, err
+	}
+
+	config.overrides.Store(&overrides)
+	config.startRefresh()
+	return config, nil
+}
+
+// urlFromEnv returns the configured pac url. The url is discovered using wpad, if requested.
+func urlFromEnv() (string, error) {
+	url := viper.GetString("pac.url")
+	if url == autoDiscoveryUrl {
+		return discoverFromEnv()
+	}
+
+	return url, nil
 }
 
 func FromUrl(url string) (*Config, error) {
-	source, err := read(url)
+	doc, err := read(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := fromDocument(doc)
 	if err != nil {
 		return nil, err
 	}
 
-	return FromSource(source)
+	config.url = url
+	return config, nil
 }
 
 func FromSource(source []byte) (*Config, error) {
-	resolve, err := compile(source)
+	return fromDocument(&document{
+		source:  source,
+		fetched: time.Now(),
+	})
+}
+
+func fromDocument(doc *document) (*Config, error) {
+	config := newConfig()
+
+	script, err := compileScript(doc.source, config.poolSize, config.evaluationTimeout)
 	if err != nil {
 		return nil, err
 	}
 
-	config := Config{
-		resolve: resolve,
-	}
-
-	return &config, nil
+	config.document = doc
+	config.current.Store(script)
+	return config, nil
 }
 
 func Direct() *Config {
+	config := newConfig()
+
+	config.current.Store(directScript())
+	return config
+}
+
+// newConfig reads the settings, that are fixed for the lifetime of the config, and snapshots the
+// settings used while resolving urls.
+func newConfig() *Config {
+	storeSettings()
+
 	return &Config{
-		resolve: func(string, string) *string {
-			return nil
+		poolSize:          max(1, viper.GetInt("pac.pool.size")),
+		evaluationTimeout: viper.GetDuration("pac.timeout.evaluation"),
+	}
+}
+
+func directScript() *script {
+	direct := script{
+		pool: make(chan resolveFunc, 1),
+	}
+
+	direct.pool <- func(string, string) (*string, error) {
+		return nil, nil
+	}
+
+	return &direct
+}
+
+// fallbackTarget parses the decision to use, if the pac evaluation is aborted. A nil target means
+// the request should fail.
+func fallbackTarget(fallback string) (*string, error) {
+	fallback = strings.TrimSpace(fallback)
+	if strings.EqualFold(fallback, fallbackFail) {
+		return nil, nil
+	}
+
+	for _, err := range parseTargetWithFallback(&fallback) {
+		if err != nil {
+			return nil, fmt.Errorf("invalid pac.fallback: %w", err)
+		}
+	}
+
+	return &fallback, nil
+}
+
+// Source returns the currently loaded pac file or nil, if connections are direct.
+func (c *Config) Source() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.document == nil {
+		return nil
+	}
+
+	return c.document.source
+}
+
+// URL returns the url the pac file is downloaded from or an empty string, if it is not downloaded.
+func (c *Config) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.url
+}
+
+// Fetched returns the time the pac file was downloaded or checked for changes the last time.
+func (c *Config) Fetched() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.document == nil {
+		return time.Time{}
+	}
+
+	return c.document.fetched
+}
+
+// OnChange registers a function, that is called whenever a new pac file has been loaded.
+func (c *Config) OnChange(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.listeners = append(c.listeners, fn)
+}
+
+// Reload downloads the pac file again and replaces the compiled script, if it changed. If the new
+// pac file cannot be compiled, the previous script is kept. The lock is not held while downloading,
+// so a hanging pac server does not block serving the current pac file.
+func (c *Config) Reload() error {
+	c.mu.Lock()
+	url, previous := c.url, c.document
+	c.mu.Unlock()
+
+	if url == "" {
+		return nil
+	}
+
+	doc, err := read(url, previous)
+	if err != nil {
+		return err
+	}
+
+	unchanged := previous != nil && bytes.Equal(doc.source, previous.source)
+
+	var compiled *script
+	if !unchanged {
+		if compiled, err = compileScript(doc.source, c.poolSize, c.evaluationTimeout); err != nil {
+			return fmt.Errorf("could not compile pac: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the url was switched or the pac file was reloaded in the meantime
+	if c.url != url || c.document != previous {
+		return nil
+	}
+
+	c.document = doc
+
+	if unchanged {
+		slog.Debug("pac is unchanged", slog.String("url", url))
+		return nil
+	}
+
+	c.current.Store(compiled)
+	slog.Info("reloaded pac", slog.String("url", url))
+
+	c.notify()
+	return nil
+}
+
+// ReloadURL switches to the currently configured pac url. An empty url switches to direct
+// connections. If the new pac cannot be loaded, the previous one is kept.
+func (c *Config) ReloadURL() error {
+	url, err := urlFromEnv()
+	if err != nil {
+		return err
+	}
+
+	var (
+		doc    *document
+		script = directScript()
+	)
+
+	if url != "" {
+		if doc, err = read(url, nil); err != nil {
+			return err
+		}
+
+		if script, err = compileScript(doc.source, c.poolSize, c.evaluationTimeout); err != nil {
+			return fmt.Errorf("could not compile pac: %w", err)
+		}
+
+		configureLocalAddress(url)
+	}
+
+	c.mu.Lock()
+	c.url = url
+	c.document = doc
+	c.current.Store(script)
+	c.notify()
+	c.mu.Unlock()
+
+	slog.Info("switched pac url", slog.String("url", url))
+
+	if url != "" {
+		c.startRefresh()
+	}
+
+	return nil
+}
+
+// ReloadOverrides replaces the override rules with the currently configured ones.
+func (c *Config) ReloadOverrides() error {
+	overrides, err := overridesFromEnv()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.overrides.Store(&overrides)
+	c.notify()
+
+	return nil
+}
+
+// Reloaders apply changed pac settings.
+func (c *Config) Reloaders() []config.Reloader {
+	return []config.Reloader{
+		{Keys: []string{"pac.url", "pac.myip.address", "pac.myip.probe"}, Apply: c.ReloadURL},
+		{Keys: []string{"pac.overrides"}, Apply: c.ReloadOverrides},
+		{
+			Keys: []string{"pac.fallback", "pac.timeout.dns"},
+			Apply: func() error {
+				storeSettings()
+				return nil
+			},
 		},
 	}
 }
 
-func (c *Config) Resolve(requestUrl *url.URL) (*url.URL, error) {
-	// The goja.Runtime is not goroutine-safe.
-	// See https://github.com/dop251/goja?tab=readme-ov-file#is-it-goroutine-safe
+// notify calls the listeners. It must be called with c.mu held.
+func (c *Config) notify() {
+	for _, fn := range c.listeners {
+		fn()
+	}
+}
+
+// startRefresh refreshes the pac periodically in the background. It is started once, even if the
+// url changes.
+func (c *Config) startRefresh() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if !c.refreshing {
+		c.refreshing = true
+		go c.refresh(viper.GetDuration("pac.refresh.interval"))
+	}
+}
+
+func (c *Config) refresh(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for {
+		var timer <-chan time.Time
+		if delay := c.refreshDelay(interval); delay > 0 {
+			timer = time.After(delay)
+		}
+
+		select {
+		case <-timer:
+			slog.Debug("refreshing pac", slog.String("url", c.URL()))
+
+		case <-hup:
+			slog.Info("received SIGHUP, reloading pac", slog.String("url", c.URL()))
+		}
+
+		if err := c.Reload(); err != nil {
+			slog.Warn("could not reload pac, keeping previous version",
+				slog.String("url", c.URL()),
+				slog.Any("err", err),
+			)
+		}
+	}
+}
+
+// refreshDelay returns the time until the next refresh. The max-age of the pac server takes
+// precedence over the configured interval. Zero disables periodic refreshes.
+func (c *Config) refreshDelay(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.document != nil && c.document.maxAge > 0 {
+		return c.document.maxAge
+	}
+
+	return interval
+}
+
+// Resolve evaluates the pac for the url and returns the upstream proxies in the order of preference.
+// A nil proxy stands for a direct connection. Override rules are consulted first and return
+// ErrBlocked for urls, that must not be accessed.
+func (c *Config) Resolve(requestUrl *url.URL) ([]*url.URL, error) {
+	candidates, _, err := c.ResolveCacheable(requestUrl)
+	return candidates, err
+}
+
+// ResolveCacheable is like Resolve, but also reports whether the result may be cached. The fallback
+// is not cacheable, because it only stands in for the pac until the evaluation succeeds again.
+func (c *Config) ResolveCacheable(requestUrl *url.URL) ([]*url.URL, bool, error) {
 	t0 := time.Now()
 
-	target := c.resolve(requestUrl.String(), requestUrl.Hostname())
-	proxies := parseTargetWithFallback(target)
+	target, source, err := c.resolveTarget(requestUrl)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var candidates []*url.URL
 
-	for proxy, err := range proxies {
+	for proxy, err := range parseTargetWithFallback(target) {
 		if err != nil {
-			return nil, err
+			slog.Warn("skipping invalid upstream proxy", slog.Any("err", err))
+			continue
 		}
 
-		slog.Debug("resolved upsteam proxy",
-			slog.Any("uri", requestUrl),
-			slog.Any("target", proxy),
-			slog.Duration("t", time.Since(t0)),
-		)
-
-		if proxy != nil && !slices.Contains(supportedUpstreamProxies, proxy.Scheme) {
+		if proxy != nil && !isSupportedUpstreamProxy(proxy) {
 			slog.Warn("skipping unsupported upstream proxy", slog.Any("target", proxy))
 			continue
 		}
 
-		return proxy, nil
+		if slices.ContainsFunc(candidates, func(candidate *url.URL) bool {
+			return sameTarget(candidate, proxy)
+		}) {
+			continue
+		}
+
+		candidates = append(candidates, proxy)
 	}
 
-	return nil, fmt.Errorf("could not resolve valid upstream proxy")
+	slog.Debug("resolved upsteam proxies",
+		slog.Any("uri", requestUrl),
+		slog.Any("targets", candidates),
+		slog.String("source", source),
+		slog.Duration("t", time.Since(t0)),
+	)
+
+	if len(candidates) == 0 {
+		return nil, false, fmt.Errorf("could not resolve valid upstream proxy")
+	}
+
+	return candidates, source != sourceFallback, nil
+}
+
+// resolveTarget returns the pac result for the url and where the decision came from.
+func (c *Config) resolveTarget(requestUrl *url.URL) (*string, string, error) {
+	if overrides := c.overrides.Load(); overrides != nil {
+		if rule, ok := override(*overrides, requestUrl); ok {
+			source := "override " + rule.String()
+
+			if strings.EqualFold(rule.Target, targetBlock) {
+				slog.Debug("blocked url", slog.Any("uri", requestUrl), slog.String("source", source))
+				return nil, source, ErrBlocked
+			}
+
+			return &rule.Target, source, nil
+		}
+	}
+
+	t0 := time.Now()
+
+	target, err := c.current.Load().resolve(requestUrl.String(), requestUrl.Hostname())
+	metrics.PacEvaluationDuration.Observe(time.Since(t0).Seconds())
+
+	if errors.Is(err, ErrEvaluationAborted) {
+		target, err = fallback(requestUrl, err)
+		return target, sourceFallback, err
+	}
+
+	return target, "pac", err
+}
+
+func isSupportedUpstreamProxy(proxy *url.URL) bool {
+	return slices.Contains(supportedUpstreamProxies, proxy.Scheme)
+}
+
+func sameTarget(a, b *url.URL) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.String() == b.String()
+}
+
+func fallback(requestUrl *url.URL, cause error) (*string, error) {
+	target, err := fallbackTarget(loadSettings().fallback)
+	if err != nil {
+		return nil, err
+	}
+
+	if target == nil {
+		slog.Warn("pac evaluation aborted", slog.Any("uri", requestUrl), slog.Any("err", cause))
+		return nil, cause
+	}
+
+	slog.Warn("pac evaluation aborted, using fallback",
+		slog.Any("uri", requestUrl),
+		slog.String("fallback", *target),
+		slog.Any("err", cause),
+	)
+
+	return target, nil
 }
 
 func parseTargetWithFallback(targets *string) iter.Seq2[*url.URL, error] {
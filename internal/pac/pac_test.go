@@ -0,0 +1,253 @@
+package pac
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	var (
+		source   atomic.Value
+		requests atomic.Int32
+	)
+
+	source.Store(`function FindProxyForURL(url, host) { return "PROXY first:8080"; }`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		etag := `"` + source.Load().(string) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(source.Load().(string)))
+	}))
+	defer server.Close()
+
+	config, err := FromUrl(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, config.refreshDelay(time.Hour))
+
+	var changes atomic.Int32
+	config.OnChange(func() { changes.Add(1) })
+
+	resolve := func() string {
+		proxies, err := config.Resolve(&url.URL{Scheme: "https", Host: "example.org"})
+		assert.NoError(t, err)
+		return proxies[0].Host
+	}
+
+	assert.Equal(t, "first:8080", resolve())
+
+	// unchanged pac
+	assert.NoError(t, config.Reload())
+	assert.Equal(t, "first:8080", resolve())
+	assert.EqualValues(t, 0, changes.Load())
+
+	// changed pac
+	source.Store(`function FindProxyForURL(url, host) { return "PROXY second:8080"; }`)
+	assert.NoError(t, config.Reload())
+	assert.Equal(t, "second:8080", resolve())
+	assert.EqualValues(t, 1, changes.Load())
+
+	// broken pac
+	source.Store(`function FindProxyForURL(url, host) {`)
+	assert.Error(t, config.Reload())
+	assert.Equal(t, "second:8080", resolve())
+	assert.EqualValues(t, 1, changes.Load())
+
+	assert.EqualValues(t, 4, requests.Load())
+}
+
+func TestReloadHanging(t *testing.T) {
+	var (
+		requests atomic.Int32
+		release  = make(chan struct{})
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) > 1 {
+			<-release
+		}
+
+		_, _ = w.Write([]byte(`function FindProxyForURL(url, host) { return "DIRECT"; }`))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config, err := FromUrl(server.URL)
+	assert.NoError(t, err)
+
+	go func() { _ = config.Reload() }()
+
+	assert.Eventually(t, func() bool { return requests.Load() == 2 }, time.Second, time.Millisecond)
+
+	// the current pac file is still served, while the download hangs
+	done := make(chan struct{})
+	go func() {
+		_ = config.Source()
+		_ = config.URL()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by the hanging reload")
+	}
+}
+
+func TestReloadURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`function FindProxyForURL(url, host) { return "PROXY corporate:8080"; }`))
+	}))
+	defer server.Close()
+
+	defer viper.Set("pac.url", "")
+
+	config := Direct()
+
+	var changes atomic.Int32
+	config.OnChange(func() { changes.Add(1) })
+
+	resolve := func() *url.URL {
+		proxies, err := config.Resolve(&url.URL{Scheme: "https", Host: "example.org"})
+		assert.NoError(t, err)
+		return proxies[0]
+	}
+
+	viper.Set("pac.url", server.URL)
+	assert.NoError(t, config.ReloadURL())
+	assert.Equal(t, &url.URL{Scheme: "proxy", Host: "corporate:8080"}, resolve())
+	assert.Equal(t, server.URL, config.URL())
+
+	// unreachable pac
+	viper.Set("pac.url", "http://127.0.0.1:1/proxy.pac")
+	assert.Error(t, config.ReloadURL())
+	assert.Equal(t, server.URL, config.URL())
+
+	viper.Set("pac.url", "")
+	assert.NoError(t, config.ReloadURL())
+	assert.Nil(t, resolve())
+	assert.Nil(t, config.Source())
+
+	assert.EqualValues(t, 2, changes.Load())
+}
+
+func TestResolveFallback(t *testing.T) {
+	defer storeSettings()
+	defer viper.Set("pac.fallback", viper.GetString("pac.fallback"))
+	defer viper.Set("pac.timeout.evaluation", viper.GetString("pac.timeout.evaluation"))
+
+	viper.Set("pac.timeout.evaluation", "50ms")
+
+	config, err := FromSource([]byte(`function FindProxyForURL(url, host) { while (true) {} }`))
+	assert.NoError(t, err)
+
+	requestUrl := &url.URL{Scheme: "https", Host: "example.org"}
+
+	viper.Set("pac.fallback", "FAIL")
+	storeSettings()
+	_, err = config.Resolve(requestUrl)
+	assert.ErrorIs(t, err, ErrEvaluationAborted)
+
+	viper.Set("pac.fallback", "DIRECT")
+	storeSettings()
+	proxies, cacheable, err := config.ResolveCacheable(requestUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, []*url.URL{nil}, proxies)
+	assert.False(t, cacheable)
+
+	// settings are only applied once they are reloaded
+	viper.Set("pac.fallback", "FAIL")
+	_, err = config.Resolve(requestUrl)
+	assert.NoError(t, err)
+
+	viper.Set("pac.fallback", "PROXY fallback:8080; DIRECT")
+	storeSettings()
+	proxies, err = config.Resolve(requestUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, []*url.URL{{Scheme: "proxy", Host: "fallback:8080"}, nil}, proxies)
+
+	_, err = fallbackTarget("SOMEWHERE")
+	assert.Error(t, err)
+}
+
+func TestResolveCandidates(t *testing.T) {
+	config, err := FromSource([]byte(`
+		function FindProxyForURL(url, host) {
+			return "PROXY a:8080; FTP b:21; SOCKS5 c:1080; INVALID; PROXY a:8080; DIRECT";
+		}
+	`))
+	assert.NoError(t, err)
+
+	proxies, err := config.Resolve(&url.URL{Scheme: "https", Host: "example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t,
+		[]*url.URL{
+			{Scheme: "proxy", Host: "a:8080"},
+			{Scheme: "socks5", Host: "c:1080"},
+			nil,
+		},
+		proxies,
+	)
+}
+
+func TestParseMaxAge(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, parseMaxAge("public, max-age=300"))
+	assert.Equal(t, time.Duration(0), parseMaxAge("no-cache"))
+	assert.Equal(t, time.Duration(0), parseMaxAge("max-age=abc"))
+	assert.Equal(t, time.Duration(0), parseMaxAge(""))
+}
+
+// slowSource simulates an expensive pac file, e.g. one with many conditions.
+const slowSource = `
+	function FindProxyForURL(url, host) {
+		var hash = 0;
+		for (var i = 0; i < 1000; i++) {
+			hash = (hash * 31 + host.charCodeAt(i % host.length)) % 65536;
+		}
+
+		if (hash < 0) {
+			return "DIRECT";
+		}
+
+		return "PROXY proxy.example.org:8080";
+	}
+`
+
+func BenchmarkResolveParallel(b *testing.B) {
+	for _, size := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			script, err := compileScript([]byte(slowSource), size, 0)
+			assert.NoError(b, err)
+
+			var config Config
+			config.current.Store(script)
+
+			requestUrl := &url.URL{Scheme: "https", Host: "example.org"}
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := config.Resolve(requestUrl); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
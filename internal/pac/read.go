@@ -6,10 +6,40 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func read(url string) ([]byte, error) {
-	r, err := client().Get(url)
+// readTimeout limits downloading the pac file, including reading the body.
+const readTimeout = 30 * time.Second
+
+// document is a downloaded pac file together with the metadata needed for conditional requests.
+type document struct {
+	source       []byte
+	etag         string
+	lastModified string
+	maxAge       time.Duration
+	fetched      time.Time
+}
+
+func read(url string, previous *document) (*document, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
+
+	r, err := client().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -17,11 +47,50 @@ func read(url string) ([]byte, error) {
 	//nolint:errcheck
 	defer r.Body.Close()
 
-	if r.StatusCode != 200 {
+	switch {
+	case r.StatusCode == http.StatusNotModified && previous != nil:
+		doc := *previous
+		doc.maxAge = parseMaxAge(r.Header.Get("Cache-Control"))
+		doc.fetched = time.Now()
+
+		return &doc, nil
+
+	case r.StatusCode != http.StatusOK:
 		return nil, fmt.Errorf("could not read pac url: %s", r.Status)
 	}
 
-	return io.ReadAll(r.Body)
+	source, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := document{
+		source:       source,
+		etag:         r.Header.Get("ETag"),
+		lastModified: r.Header.Get("Last-Modified"),
+		maxAge:       parseMaxAge(r.Header.Get("Cache-Control")),
+		fetched:      time.Now(),
+	}
+
+	return &doc, nil
+}
+
+// parseMaxAge returns the max-age directive of a Cache-Control header or zero, if there is none.
+func parseMaxAge(cacheControl string) time.Duration {
+	for directive := range strings.SplitSeq(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		if strings.EqualFold(name, "max-age") {
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || seconds < 0 {
+				return 0
+			}
+
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return 0
 }
 
 func client() *http.Client {
@@ -35,5 +104,6 @@ func client() *http.Client {
 
 	return &http.Client{
 		Transport: &transport,
+		Timeout:   readTimeout,
 	}
 }
@@ -0,0 +1,303 @@
+package pac
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("pac.wpad.resolvconf", config.String, "/etc/resolv.conf")
+	config.Define("pac.wpad.dhcp", config.Bool, false)
+	config.Define("pac.wpad.timeout", config.Duration, "5s")
+}
+
+const (
+	// autoDiscoveryUrl is the value of pac.url to enable web proxy auto-discovery.
+	autoDiscoveryUrl = "auto"
+
+	dhcpServerPort     = 67
+	dhcpClientPort     = 68
+	dhcpOptionPad      = 0
+	dhcpOptionType     = 53
+	dhcpOptionParams   = 55
+	dhcpOptionWpad     = 252
+	dhcpOptionEnd      = 255
+	dhcpMessageInform  = 8
+	dhcpOpRequest      = 1
+	dhcpOpReply        = 2
+	dhcpHeaderLength   = 236
+	dhcpHardwareEth    = 1
+	dhcpHardwareLength = 6
+)
+
+var (
+	dhcpMagicCookie = []byte{99, 130, 83, 99}
+
+	errNoWpad = errors.New("could not discover a pac url using wpad")
+)
+
+// wpad implements the web proxy auto-discovery protocol.
+// See https://datatracker.ietf.org/doc/html/draft-ietf-wrec-wpad-01
+type wpad struct {
+	resolver   *net.Resolver
+	resolvConf string
+	// dhcp asks the dhcp server for the pac url, if enabled.
+	dhcp    func(context.Context) (string, error)
+	timeout time.Duration
+}
+
+func discoverFromEnv() (string, error) {
+	w := wpad{
+		resolver:   net.DefaultResolver,
+		resolvConf: viper.GetString("pac.wpad.resolvconf"),
+		timeout:    viper.GetDuration("pac.wpad.timeout"),
+	}
+
+	if viper.GetBool("pac.wpad.dhcp") {
+		w.dhcp = discoverDhcp
+	}
+
+	return w.discover(context.Background())
+}
+
+// discover returns the url of the pac file. DHCP takes precedence over DNS, because it is more
+// specific to the current network. Without a dhcp reply, the dhcp query runs until its deadline, so
+// it only gets half of the timeout and DNS gets the full timeout afterwards.
+func (w *wpad) discover(ctx context.Context) (string, error) {
+	if w.dhcp != nil {
+		dhcpCtx, cancel := context.WithTimeout(ctx, w.timeout/2)
+		url, err := w.dhcp(dhcpCtx)
+		cancel()
+
+		if err == nil {
+			slog.Info("discovered pac url via dhcp", slog.String("url", url))
+			return url, nil
+		}
+
+		slog.Debug("could not discover pac url via dhcp", slog.Any("err", err))
+	}
+
+	domains, err := readSearchDomains(w.resolvConf)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	for _, host := range wpadCandidates(domains) {
+		if _, err := w.resolver.LookupHost(ctx, host); err != nil {
+			slog.Debug("wpad candidate is not resolvable", slog.String("host", host), slog.Any("err", err))
+			continue
+		}
+
+		url := fmt.Sprintf("http://%s/wpad.dat", host)
+		slog.Info("discovered pac url via dns", slog.String("url", url))
+
+		return url, nil
+	}
+
+	return "", errNoWpad
+}
+
+// readSearchDomains returns the "domain" and "search" entries of a resolv.conf file.
+func readSearchDomains(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck
+	defer f.Close()
+
+	var domains []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "domain", "search":
+			domains = append(domains, fields[1:]...)
+		}
+	}
+
+	return domains, scanner.Err()
+}
+
+// wpadCandidates returns the hosts to query for each domain, walking up the domain tree, but never
+// querying a top level domain. For "a.example.org" that is "wpad.a.example.org" and
+// "wpad.example.org".
+func wpadCandidates(domains []string) []string {
+	var (
+		candidates []string
+		seen       = make(map[string]bool)
+	)
+
+	for _, domain := range domains {
+		labels := strings.Split(strings.Trim(domain, "."), ".")
+
+		for i := 0; len(labels)-i >= 2; i++ {
+			host := "wpad." + strings.Join(labels[i:], ".")
+
+			if !seen[host] {
+				seen[host] = true
+				candidates = append(candidates, host)
+			}
+		}
+	}
+
+	return candidates
+}
+
+// discoverDhcp broadcasts a DHCPINFORM message asking for option 252, which contains the pac url.
+// Binding the dhcp client port usually requires elevated privileges.
+func discoverDhcp(ctx context.Context) (string, error) {
+	local, hardwareAddr, err := localInterface()
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: dhcpClientPort})
+	if err != nil {
+		return "", err
+	}
+
+	//nolint:errcheck
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	xid := make([]byte, 4)
+	if _, err := rand.Read(xid); err != nil {
+		return "", err
+	}
+
+	request := dhcpInform(xid, local, hardwareAddr)
+	if _, err := conn.WriteToUDP(request, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpServerPort}); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return "", err
+		}
+
+		if url, ok := parseDhcpWpad(buf[:n], xid); ok {
+			return url, nil
+		}
+	}
+}
+
+// localInterface returns the ipv4 address and hardware address of the interface used for the
+// default route.
+func localInterface() (net.IP, net.HardwareAddr, error) {
+	addr, err := detectLocalAddress(viper.GetString("pac.myip.probe"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	local := net.ParseIP(addr).To4()
+	if local == nil {
+		return nil, nil, fmt.Errorf("local address %s is not an ipv4 address", addr)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, ifaceAddr := range addrs {
+			if ipNet, ok := ifaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return local, iface.HardwareAddr, nil
+			}
+		}
+	}
+
+	return nil, nil, fmt.Errorf("could not find interface for local address %s", addr)
+}
+
+func dhcpInform(xid []byte, local net.IP, hardwareAddr net.HardwareAddr) []byte {
+	packet := make([]byte, dhcpHeaderLength)
+
+	packet[0] = dhcpOpRequest
+	packet[1] = dhcpHardwareEth
+	packet[2] = dhcpHardwareLength
+	copy(packet[4:8], xid)
+	copy(packet[12:16], local.To4())
+	copy(packet[28:44], hardwareAddr)
+
+	packet = append(packet, dhcpMagicCookie...)
+	packet = append(packet,
+		dhcpOptionType, 1, dhcpMessageInform,
+		dhcpOptionParams, 1, dhcpOptionWpad,
+		dhcpOptionEnd,
+	)
+
+	return packet
+}
+
+func parseDhcpWpad(packet, xid []byte) (string, bool) {
+	if len(packet) < dhcpHeaderLength+len(dhcpMagicCookie) ||
+		packet[0] != dhcpOpReply ||
+		!bytes.Equal(packet[4:8], xid) ||
+		!bytes.Equal(packet[dhcpHeaderLength:dhcpHeaderLength+4], dhcpMagicCookie) {
+		return "", false
+	}
+
+	options := packet[dhcpHeaderLength+4:]
+	for len(options) > 0 {
+		code := options[0]
+
+		switch code {
+		case dhcpOptionPad:
+			options = options[1:]
+			continue
+
+		case dhcpOptionEnd:
+			return "", false
+		}
+
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return "", false
+		}
+
+		value := options[2 : 2+options[1]]
+		if code == dhcpOptionWpad {
+			url := string(bytes.TrimRight(value, "\x00"))
+			return url, url != ""
+		}
+
+		options = options[2+len(value):]
+	}
+
+	return "", false
+}
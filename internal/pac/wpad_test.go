@@ -0,0 +1,164 @@
+package pac
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveDns starts a dns server on localhost, that answers A queries for the given hosts and
+// returns a resolver using it.
+func serveDns(t *testing.T, hosts ...string) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	known := make(map[string]bool)
+	for _, host := range hosts {
+		known[host+"."] = true
+	}
+
+	go func() {
+		buf := make([]byte, 512)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var request dnsmessage.Message
+			if err := request.Unpack(buf[:n]); err != nil || len(request.Questions) != 1 {
+				continue
+			}
+
+			question := request.Questions[0]
+			response := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:            request.ID,
+					Response:      true,
+					Authoritative: true,
+					RCode:         dnsmessage.RCodeNameError,
+				},
+				Questions: request.Questions,
+			}
+
+			if known[strings.ToLower(question.Name.String())] {
+				response.RCode = dnsmessage.RCodeSuccess
+
+				if question.Type == dnsmessage.TypeA {
+					response.Answers = []dnsmessage.Resource{{
+						Header: dnsmessage.ResourceHeader{
+							Name:  question.Name,
+							Type:  dnsmessage.TypeA,
+							Class: dnsmessage.ClassINET,
+							TTL:   60,
+						},
+						Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+					}}
+				}
+			}
+
+			packed, err := response.Pack()
+			if err != nil {
+				continue
+			}
+
+			_, _ = conn.WriteTo(packed, addr)
+		}
+	}()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func writeResolvConf(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestWpadDiscoverDns(t *testing.T) {
+	w := wpad{
+		resolver:   serveDns(t, "wpad.example.org"),
+		resolvConf: writeResolvConf(t, "nameserver 192.0.2.53\nsearch dev.team.example.org example.com\n"),
+		timeout:    5 * time.Second,
+	}
+
+	url, err := w.discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "http://wpad.example.org/wpad.dat", url)
+}
+
+func TestWpadDiscoverDnsAfterDhcpTimeout(t *testing.T) {
+	w := wpad{
+		resolver:   serveDns(t, "wpad.example.org"),
+		resolvConf: writeResolvConf(t, "search example.org\n"),
+		// no dhcp reply uses up the whole deadline of the dhcp query
+		dhcp: func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+		timeout: 200 * time.Millisecond,
+	}
+
+	url, err := w.discover(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "http://wpad.example.org/wpad.dat", url)
+}
+
+func TestWpadDiscoverDnsNotFound(t *testing.T) {
+	w := wpad{
+		resolver:   serveDns(t),
+		resolvConf: writeResolvConf(t, "domain example.org\n"),
+		timeout:    5 * time.Second,
+	}
+
+	_, err := w.discover(context.Background())
+	assert.ErrorIs(t, err, errNoWpad)
+}
+
+func TestWpadCandidates(t *testing.T) {
+	assert.Equal(t,
+		[]string{
+			"wpad.dev.team.example.org",
+			"wpad.team.example.org",
+			"wpad.example.org",
+			"wpad.example.com",
+		},
+		wpadCandidates([]string{"dev.team.example.org", "example.org.", "example.com", "localdomain"}),
+	)
+}
+
+func TestParseDhcpWpad(t *testing.T) {
+	xid := []byte{1, 2, 3, 4}
+
+	reply := dhcpInform(xid, net.IPv4(192, 0, 2, 10), net.HardwareAddr{0, 1, 2, 3, 4, 5})
+	reply[0] = dhcpOpReply
+	reply = reply[:dhcpHeaderLength+len(dhcpMagicCookie)]
+	reply = append(reply, dhcpOptionPad, dhcpOptionType, 1, 5, dhcpOptionWpad, 29)
+	reply = append(reply, "http://wpad.example.org/a.pac\x00"[:29]...)
+	reply = append(reply, dhcpOptionEnd)
+
+	url, ok := parseDhcpWpad(reply, xid)
+	assert.True(t, ok)
+	assert.Equal(t, "http://wpad.example.org/a.pac", url)
+
+	_, ok = parseDhcpWpad(reply, []byte{4, 3, 2, 1})
+	assert.False(t, ok)
+
+	_, ok = parseDhcpWpad(reply[:dhcpHeaderLength+8], xid)
+	assert.False(t, ok)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/match"
+)
+
+func init() {
+	config.Define("http.allow", config.Structure, "")
+	config.Define("http.deny", config.Structure, "")
+	config.AddValidator(func() error {
+		_, err := prefixesFromEnv("http.allow")
+		return err
+	})
+	config.AddValidator(func() error {
+		_, err := prefixesFromEnv("http.deny")
+		return err
+	})
+}
+
+// accessList restricts the client addresses, that may use proxyproxy. Denied networks take
+// precedence. If there are allowed networks, clients need to be part of one of them.
+type accessList struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// accessListFromEnv returns nil, if all clients are allowed.
+func accessListFromEnv() (*accessList, error) {
+	allow, err := prefixesFromEnv("http.allow")
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := prefixesFromEnv("http.deny")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+
+	return &accessList{allow: allow, deny: deny}, nil
+}
+
+func prefixesFromEnv(key string) ([]netip.Prefix, error) {
+	var entries []string
+	if err := config.UnmarshalKey(key, &entries); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		prefix, err := match.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// allowed reports whether the client of the request may use proxyproxy.
+func (a *accessList) allowed(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr := addrPort.Addr().Unmap()
+
+	if match.ContainsAddr(a.deny, addr) {
+		return false
+	}
+
+	return len(a.allow) == 0 || match.ContainsAddr(a.allow, addr)
+}
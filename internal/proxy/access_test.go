@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessList(t *testing.T) {
+	defer viper.Set("http.allow", "")
+	defer viper.Set("http.deny", "")
+
+	viper.Set("http.allow", "10.0.0.0/8, 192.168.1.5, fd00::/8")
+	viper.Set("http.deny", "10.0.13.0/24")
+
+	access, err := accessListFromEnv()
+	assert.NoError(t, err)
+
+	for remoteAddr, allowed := range map[string]bool{
+		"10.1.2.3:4000":            true,
+		"10.0.13.37:4000":          false,
+		"192.168.1.5:4000":         true,
+		"192.168.1.6:4000":         false,
+		"[::ffff:10.1.2.3]:4000":   true,
+		"[::ffff:10.0.13.37]:4000": false,
+		"[fd00::1]:4000":           true,
+		"[2001:db8::1]:4000":       false,
+		"invalid":                  false,
+	} {
+		assert.Equal(t, allowed, access.allowed(&http.Request{RemoteAddr: remoteAddr}), remoteAddr)
+	}
+
+	viper.Set("http.allow", "")
+	viper.Set("http.deny", "")
+
+	access, err = accessListFromEnv()
+	assert.NoError(t, err)
+	assert.Nil(t, access)
+
+	viper.Set("http.deny", "10.0.0.0/33")
+	_, err = accessListFromEnv()
+	assert.Error(t, err)
+}
+
+func TestAccessListDenied(t *testing.T) {
+	defer viper.Set("http.deny", "")
+	viper.Set("http.deny", "127.0.0.0/8, ::1")
+
+	// the pac is never evaluated for denied clients
+	proxy := newProxy(t, `function FindProxyForURL(url, host) { throw "evaluated"; }`)
+
+	res := get(t, proxy, serveHello(t).URL)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
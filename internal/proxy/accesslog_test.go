@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type accessLogLine struct {
+	ID       string `json:"id"`
+	Method   string `json:"method"`
+	Target   string `json:"target"`
+	Upstream string `json:"upstream"`
+	Status   int    `json:"status"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+}
+
+func readAccessLog(t testing.TB, path string) []accessLogLine {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	assert.NoError(t, err)
+
+	//nolint:errcheck
+	defer f.Close()
+
+	var lines []accessLogLine
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line accessLogLine
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestAccessLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	viper.Set("accesslog.path", path)
+	defer viper.Set("accesslog.path", "")
+
+	upstream := httptest.NewServer(fakeUpstream())
+	defer upstream.Close()
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+	hello := serveHello(t)
+
+	res := get(t, proxy, hello.URL)
+	_, _ = io.Copy(io.Discard, res.Body)
+	_ = res.Body.Close()
+
+	echo := serveEcho(t).Addr().String()
+	assert.NoError(t, connect(proxy.Listener.Addr().String(), echo))
+
+	// tunnels are logged after they are closed
+	assert.Eventually(t, func() bool {
+		return len(readAccessLog(t, path)) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	lines := readAccessLog(t, path)
+
+	assert.Equal(t, http.MethodGet, lines[0].Method)
+	assert.Equal(t, hello.URL+"/", lines[0].Target)
+	assert.Equal(t, "proxy://"+upstream.Listener.Addr().String(), lines[0].Upstream)
+	assert.Equal(t, http.StatusOK, lines[0].Status)
+	assert.EqualValues(t, len("hello"), lines[0].BytesIn)
+
+	assert.Equal(t, http.MethodConnect, lines[1].Method)
+	assert.Equal(t, echo, lines[1].Target)
+	assert.Equal(t, http.StatusOK, lines[1].Status)
+	assert.EqualValues(t, len("ping"), lines[1].BytesIn)
+	assert.EqualValues(t, len("ping"), lines[1].BytesOut)
+	assert.NotEqual(t, lines[0].ID, lines[1].ID)
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
+)
+
+var (
+	errInvalidResolveUrl = errors.New("query parameter url must be an absolute url")
+)
+
+// Admin returns the api to inspect and control the handler. It must not be reachable by clients of
+// the proxy, as it is not authenticated.
+func (h *Handler) Admin() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /tunnels", h.adminTunnels)
+	mux.HandleFunc("GET /pac", h.adminPac)
+	mux.HandleFunc("POST /pac/reload", h.adminReloadPac)
+	mux.HandleFunc("GET /cache", h.adminCache)
+	mux.HandleFunc("DELETE /cache", h.adminFlushCache)
+	mux.HandleFunc("GET /resolve", h.adminResolve)
+
+	if metrics.Enabled() {
+		mux.Handle("GET /metrics", metrics.Handler())
+	}
+
+	return mux
+}
+
+type tunnelView struct {
+	ID       string    `json:"id"`
+	Client   string    `json:"client"`
+	Target   string    `json:"target"`
+	Upstream string    `json:"upstream"`
+	Started  time.Time `json:"started"`
+	Age      string    `json:"age"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+}
+
+func (h *Handler) adminTunnels(w http.ResponseWriter, _ *http.Request) {
+	views := []tunnelView{}
+
+	for _, tun := range h.tunnels.list() {
+		views = append(views, tunnelView{
+			ID:       tun.record.id.String(),
+			Client:   tun.client,
+			Target:   tun.target,
+			Upstream: formatUpstream(tun.upstream),
+			Started:  tun.record.started,
+			Age:      time.Since(tun.record.started).Truncate(time.Second).String(),
+			BytesIn:  tun.record.bytesIn.Load(),
+			BytesOut: tun.record.bytesOut.Load(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+type pacView struct {
+	URL     string    `json:"url"`
+	Fetched time.Time `json:"fetched"`
+	Source  string    `json:"source"`
+}
+
+func (h *Handler) adminPac(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, pacView{
+		URL:     h.upstream.URL(),
+		Fetched: h.upstream.Fetched(),
+		Source:  string(h.upstream.Source()),
+	})
+}
+
+func (h *Handler) adminReloadPac(w http.ResponseWriter, _ *http.Request) {
+	slog.Info("reloading pac on request of the admin api")
+
+	if err := h.upstream.Reload(); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	h.adminPac(w, nil)
+}
+
+type cacheView struct {
+	Key        string    `json:"key"`
+	Upstreams  []string  `json:"upstreams"`
+	Expiration time.Time `json:"expiration"`
+}
+
+func (h *Handler) adminCache(w http.ResponseWriter, _ *http.Request) {
+	views := []cacheView{}
+
+	for _, entry := range h.cache.Entries() {
+		views = append(views, cacheView{
+			Key:        entry.Key,
+			Upstreams:  formatUpstreams(entry.Value),
+			Expiration: entry.Expiration,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (h *Handler) adminFlushCache(w http.ResponseWriter, _ *http.Request) {
+	slog.Info("flushing cache on request of the admin api")
+
+	h.cache.Flush()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type resolveView struct {
+	URL       string   `json:"url"`
+	Upstreams []string `json:"upstreams"`
+}
+
+// adminResolve evaluates the url like a proxied request would, but bypasses the cache.
+func (h *Handler) adminResolve(w http.ResponseWriter, r *http.Request) {
+	requestUrl, err := url.Parse(r.URL.Query().Get("url"))
+	if err != nil || requestUrl.Host == "" {
+		writeError(w, http.StatusBadRequest, errInvalidResolveUrl)
+		return
+	}
+
+	upstreams, err := h.upstream.Resolve(stripUrl(requestUrl))
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resolveView{
+		URL:       requestUrl.String(),
+		Upstreams: formatUpstreams(upstreams),
+	})
+}
+
+// formatUpstream returns the url of the upstream proxy or DIRECT.
+func formatUpstream(upstream *url.URL) string {
+	if upstream == nil {
+		return "DIRECT"
+	}
+
+	return upstream.String()
+}
+
+func formatUpstreams(upstreams []*url.URL) []string {
+	formatted := make([]string, len(upstreams))
+	for i, upstream := range upstreams {
+		formatted[i] = formatUpstream(upstream)
+	}
+
+	return formatted
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("could not write admin response", slog.Any("err", err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
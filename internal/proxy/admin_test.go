@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+func adminRequest(t testing.TB, method, url string, v any) int {
+	req, err := http.NewRequest(method, url, nil)
+	assert.NoError(t, err)
+
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+
+	//nolint:errcheck
+	defer res.Body.Close()
+
+	if v != nil {
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(v))
+	}
+
+	return res.StatusCode
+}
+
+func TestAdmin(t *testing.T) {
+	upstream, err := pac.FromSource([]byte(pacSource("DIRECT")))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	admin := httptest.NewServer(handler.Admin())
+	defer admin.Close()
+
+	t.Run("tunnels", func(t *testing.T) {
+		echo := serveEcho(t)
+
+		conn, err := openTunnel(proxy.Listener.Addr().String(), echo.Addr().String())
+		assert.NoError(t, err)
+
+		var tunnels []tunnelView
+		assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, admin.URL+"/tunnels", &tunnels))
+
+		if assert.Len(t, tunnels, 1) {
+			assert.Equal(t, echo.Addr().String(), tunnels[0].Target)
+			assert.Equal(t, "DIRECT", tunnels[0].Upstream)
+			assert.EqualValues(t, 4, tunnels[0].BytesIn)
+			assert.EqualValues(t, 4, tunnels[0].BytesOut)
+		}
+
+		assert.NoError(t, conn.Close())
+
+		assert.Eventually(t, func() bool {
+			return len(handler.tunnels.list()) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, admin.URL+"/cache", nil))
+
+		hello := serveHello(t)
+
+		res := get(t, proxy, hello.URL)
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+
+		var entries []cacheView
+		assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, admin.URL+"/cache", &entries))
+
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, hello.URL, entries[0].Key)
+			assert.Equal(t, []string{"DIRECT"}, entries[0].Upstreams)
+		}
+
+		assert.Equal(t, http.StatusNoContent, adminRequest(t, http.MethodDelete, admin.URL+"/cache", nil))
+		assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet, admin.URL+"/cache", &entries))
+		assert.Empty(t, entries)
+	})
+
+	t.Run("pac", func(t *testing.T) {
+		var view pacView
+		assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodPost, admin.URL+"/pac/reload", &view))
+		assert.Equal(t, pacSource("DIRECT"), view.Source)
+		assert.False(t, view.Fetched.IsZero())
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		var view resolveView
+		assert.Equal(t, http.StatusOK, adminRequest(t, http.MethodGet,
+			admin.URL+"/resolve?url="+url.QueryEscape("https://example.org/path"), &view))
+		assert.Equal(t, []string{"DIRECT"}, view.Upstreams)
+
+		assert.Equal(t, http.StatusBadRequest, adminRequest(t, http.MethodGet,
+			admin.URL+"/resolve?url=example.org", nil))
+	})
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("http.auth.htpasswd", config.String, "")
+	config.Define("http.auth.token", config.Secret, "")
+	config.Define("http.auth.realm", config.String, "proxyproxy")
+	config.AddValidator(func() error {
+		_, err := clientAuthFromEnv()
+		return err
+	})
+}
+
+// clientAuth authenticates clients of proxyproxy using basic credentials from a htpasswd file or a
+// static token. The token is either sent as bearer token or as password of basic credentials.
+type clientAuth struct {
+	realm string
+	token string
+	// users maps usernames to bcrypt hashes.
+	users map[string][]byte
+	// verified remembers the hashes of successfully verified credentials, because bcrypt is slow on
+	// purpose.
+	verified sync.Map
+}
+
+// clientAuthFromEnv returns nil, if client authentication is disabled.
+func clientAuthFromEnv() (*clientAuth, error) {
+	auth := clientAuth{
+		realm: viper.GetString("http.auth.realm"),
+		token: viper.GetString("http.auth.token"),
+	}
+
+	if path := viper.GetString("http.auth.htpasswd"); path != "" {
+		users, err := readHtpasswd(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid http.auth.htpasswd: %w", err)
+		}
+
+		auth.users = users
+	}
+
+	if auth.token == "" && auth.users == nil {
+		return nil, nil
+	}
+
+	return &auth, nil
+}
+
+// readHtpasswd reads a htpasswd file. Only bcrypt hashes (htpasswd -B) are supported.
+func readHtpasswd(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	//nolint:errcheck
+	defer f.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected username:hash", path, line)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: password of %q is not hashed using bcrypt", path, line, username)
+		}
+
+		users[username] = []byte(hash)
+	}
+
+	return users, scanner.Err()
+}
+
+// authenticate checks the Proxy-Authorization header and returns the username of the client.
+func (a *clientAuth) authenticate(r *http.Request) (string, bool) {
+	scheme, credentials, _ := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+
+	switch {
+	case strings.EqualFold(scheme, "Bearer"):
+		return "", a.validToken(credentials)
+
+	case strings.EqualFold(scheme, "Basic"):
+		decoded, err := base64.StdEncoding.DecodeString(credentials)
+		if err != nil {
+			return "", false
+		}
+
+		username, password, _ := strings.Cut(string(decoded), ":")
+		if a.validToken(password) || a.validPassword(username, password) {
+			return username, true
+		}
+	}
+
+	return "", false
+}
+
+func (a *clientAuth) validToken(token string) bool {
+	return a.token != "" && subtle.ConstantTimeCompare([]byte(a.token), []byte(token)) == 1
+}
+
+func (a *clientAuth) validPassword(username, password string) bool {
+	hash, ok := a.users[username]
+	if !ok {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(username + ":" + password))
+	if _, ok := a.verified.Load(key); ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	a.verified.Store(key, struct{}{})
+	return true
+}
+
+// challenge answers unauthenticated requests.
+func (a *clientAuth) challenge(w http.ResponseWriter) {
+	w.Header().Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", a.realm))
+	http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestClientAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+	assert.NoError(t, os.WriteFile(htpasswd, fmt.Appendf(nil, "# users\nalice:%s\n", hash), 0o600))
+
+	viper.Set("http.auth.htpasswd", htpasswd)
+	viper.Set("http.auth.token", "t0ken")
+	defer viper.Set("http.auth.htpasswd", "")
+	defer viper.Set("http.auth.token", "")
+
+	// the upstream proxy must never see the credentials of the client
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+		fakeUpstream().ServeHTTP(w, r)
+	}))
+	defer upstream.Close()
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+	target := serveHello(t).URL
+
+	request := func(user *url.Userinfo, header string) *http.Response {
+		proxyUrl, err := url.Parse(proxy.URL)
+		assert.NoError(t, err)
+		proxyUrl.User = user
+
+		transport := http.Transport{Proxy: http.ProxyURL(proxyUrl)}
+
+		req, err := http.NewRequest(http.MethodGet, target, nil)
+		assert.NoError(t, err)
+
+		if header != "" {
+			req.Header.Set("Proxy-Authorization", header)
+		}
+
+		res, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+		_ = res.Body.Close()
+
+		return res
+	}
+
+	for name, tc := range map[string]struct {
+		user   *url.Userinfo
+		header string
+		status int
+	}{
+		"missing":        {status: http.StatusProxyAuthRequired},
+		"wrong password": {user: url.UserPassword("alice", "wrong"), status: http.StatusProxyAuthRequired},
+		"unknown user":   {user: url.UserPassword("bob", "secret"), status: http.StatusProxyAuthRequired},
+		"htpasswd":       {user: url.UserPassword("alice", "secret"), status: http.StatusOK},
+		"basic token":    {user: url.UserPassword("anyone", "t0ken"), status: http.StatusOK},
+		"bearer token":   {header: "Bearer t0ken", status: http.StatusOK},
+		"wrong token":    {header: "Bearer wrong", status: http.StatusProxyAuthRequired},
+	} {
+		t.Run(name, func(t *testing.T) {
+			res := request(tc.user, tc.header)
+			assert.Equal(t, tc.status, res.StatusCode)
+
+			if tc.status == http.StatusProxyAuthRequired {
+				assert.Equal(t, `Basic realm="proxyproxy"`, res.Header.Get("Proxy-Authenticate"))
+			}
+		})
+	}
+
+	t.Run("connect", func(t *testing.T) {
+		assert.EqualError(t,
+			connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()),
+			"unexpected status 407 Proxy Authentication Required")
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		proxyUrl, err := url.Parse(proxy.URL)
+		assert.NoError(t, err)
+		proxyUrl.User = url.UserPassword("alice", "secret")
+
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+
+		res, err := client.Get(target)
+		assert.NoError(t, err)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	})
+}
+
+func TestReadHtpasswd(t *testing.T) {
+	htpasswd := filepath.Join(t.TempDir(), "htpasswd")
+
+	assert.NoError(t, os.WriteFile(htpasswd, []byte("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0o600))
+	_, err := readHtpasswd(htpasswd)
+	assert.Error(t, err)
+
+	assert.NoError(t, os.WriteFile(htpasswd, []byte("alice\n"), 0o600))
+	_, err = readHtpasswd(htpasswd)
+	assert.Error(t, err)
+}
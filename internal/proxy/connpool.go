@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxIdleConnsPerKey = 2
+	idleConnTimeout    = 90 * time.Second
+)
+
+// connPool keeps connections to upstream proxies, that are authenticated by a connection based
+// scheme like ntlm, so following requests do not need another handshake.
+type connPool struct {
+	mu   sync.Mutex
+	idle map[connKey][]*pooledConn
+}
+
+// connKey identifies the upstream proxy and the credential a connection is authenticated with.
+type connKey struct {
+	upstream   string
+	credential *credential
+}
+
+type pooledConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	since  time.Time
+}
+
+func newConnPool() *connPool {
+	return &connPool{idle: make(map[connKey][]*pooledConn)}
+}
+
+// get returns the most recently used idle connection for the key or nil, if there is none.
+func (p *connPool) get(key connKey) *pooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for conns := p.idle[key]; len(conns) > 0; conns = conns[:len(conns)-1] {
+		pc := conns[len(conns)-1]
+		if time.Since(pc.since) < idleConnTimeout {
+			p.store(key, conns[:len(conns)-1])
+			return pc
+		}
+
+		_ = pc.conn.Close()
+	}
+
+	delete(p.idle, key)
+	return nil
+}
+
+// put returns the connection to the pool. Connections exceeding the limit per key are closed.
+func (p *connPool) put(key connKey, pc *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closeExpired()
+
+	if len(p.idle[key]) >= maxIdleConnsPerKey {
+		_ = pc.conn.Close()
+		return
+	}
+
+	pc.since = time.Now()
+	p.idle[key] = append(p.idle[key], pc)
+}
+
+// flush closes all idle connections, so that the next requests authenticate again.
+func (p *connPool) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, conns := range p.idle {
+		for _, pc := range conns {
+			_ = pc.conn.Close()
+		}
+
+		delete(p.idle, key)
+	}
+}
+
+func (p *connPool) closeExpired() {
+	for key, conns := range p.idle {
+		var kept []*pooledConn
+		for _, pc := range conns {
+			if time.Since(pc.since) < idleConnTimeout {
+				kept = append(kept, pc)
+			} else {
+				_ = pc.conn.Close()
+			}
+		}
+
+		p.store(key, kept)
+	}
+}
+
+func (p *connPool) store(key connKey, conns []*pooledConn) {
+	if len(conns) == 0 {
+		delete(p.idle, key)
+	} else {
+		p.idle[key] = conns
+	}
+}
+
+// pooledBody returns the connection to the pool along with the response body. Closing the body
+// reads the rest of it, so the connection is ready for the next request, unless it failed.
+type pooledBody struct {
+	io.ReadCloser
+	pool     *connPool
+	key      connKey
+	conn     *pooledConn
+	reusable bool
+}
+
+func (b *pooledBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	if err == nil && b.reusable && b.conn.reader.Buffered() == 0 {
+		b.pool.put(b.key, b.conn)
+	} else {
+		_ = b.conn.conn.Close()
+	}
+
+	return err
+}
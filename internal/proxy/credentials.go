@@ -0,0 +1,352 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gobwas/glob"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("upstream.auth", config.Structure, "")
+	config.AddValidator(func() error {
+		_, err := credentialsFromEnv()
+		return err
+	})
+}
+
+const (
+	schemeBasic     = "basic"
+	schemeNTLM      = "ntlm"
+	schemeNegotiate = "negotiate"
+)
+
+// credential configures the authentication with upstream proxies, that match a host:port pattern.
+type credential struct {
+	// Match is a pattern like "proxy.example.org:8080", "*.example.org" or "*". A missing port
+	// matches every port.
+	Match string `mapstructure:"match"`
+	// Scheme is either "basic" (the default), "ntlm" or "negotiate".
+	Scheme   string `mapstructure:"scheme"`
+	Username string `mapstructure:"username"`
+	// Domain is only used by ntlm. It can also be given as part of the username "DOMAIN\user".
+	Domain string `mapstructure:"domain"`
+	// PasswordFile or PasswordEnv name the file or environment variable containing the password.
+	PasswordFile string `mapstructure:"password_file"`
+	PasswordEnv  string `mapstructure:"password_env"`
+	// Keytab and CCache are only used by negotiate. Without a keytab, the credential cache is used,
+	// which defaults to KRB5CCNAME.
+	Keytab string `mapstructure:"keytab"`
+	CCache string `mapstructure:"ccache"`
+
+	host glob.Glob
+	port glob.Glob
+}
+
+func (c *credential) compile() error {
+	if c.Match == "" {
+		return fmt.Errorf("match is required")
+	}
+
+	switch c.Scheme = strings.ToLower(c.Scheme); c.Scheme {
+	case "":
+		c.Scheme = schemeBasic
+	case schemeBasic, schemeNTLM, schemeNegotiate:
+	default:
+		return fmt.Errorf("unsupported scheme %q", c.Scheme)
+	}
+
+	// negotiate takes the username from the credential cache
+	if c.Username == "" && (c.Scheme != schemeNegotiate || c.Keytab != "") {
+		return fmt.Errorf("username is required")
+	}
+
+	if c.PasswordFile != "" && c.PasswordEnv != "" {
+		return fmt.Errorf("password_file and password_env are mutually exclusive")
+	}
+
+	host, port, err := net.SplitHostPort(c.Match)
+	if err != nil {
+		host, port = c.Match, "*"
+	}
+
+	if c.host, err = glob.Compile(strings.ToLower(host)); err != nil {
+		return err
+	}
+
+	c.port, err = glob.Compile(port)
+	return err
+}
+
+func (c *credential) matches(upstream *url.URL) bool {
+	return c.host.Match(strings.ToLower(upstream.Hostname())) && c.port.Match(upstream.Port())
+}
+
+func (c *credential) readPassword() (string, error) {
+	switch {
+	case c.PasswordFile != "":
+		password, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(password), "\r\n"), nil
+
+	case c.PasswordEnv != "":
+		password, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", c.PasswordEnv)
+		}
+
+		return password, nil
+
+	default:
+		return "", nil
+	}
+}
+
+// credentials authenticate with upstream proxies. The first matching credential is used.
+// Passwords are read once and cached until the upstream proxy rejects them.
+type credentials struct {
+	mu        sync.Mutex
+	entries   []*credential
+	passwords map[*credential]string
+	clients   map[*credential]*kerberosSession
+	// kerberosConfig is the path of the krb5.conf used by negotiate.
+	kerberosConfig string
+}
+
+func credentialsFromEnv() (*credentials, error) {
+	var entries []*credential
+	if err := config.UnmarshalKey("upstream.auth", &entries); err != nil {
+		return nil, fmt.Errorf("invalid upstream.auth: %w", err)
+	}
+
+	for i, entry := range entries {
+		if err := entry.compile(); err != nil {
+			return nil, fmt.Errorf("invalid upstream.auth[%d]: %w", i, err)
+		}
+	}
+
+	return &credentials{
+		entries:        entries,
+		passwords:      make(map[*credential]string),
+		clients:        make(map[*credential]*kerberosSession),
+		kerberosConfig: viper.GetString("upstream.kerberos.config"),
+	}, nil
+}
+
+// replace swaps in the entries of reloaded credentials. The cached passwords and kerberos clients
+// belong to the previous entries and are discarded. Kerberos clients are destroyed, once the
+// handshakes using them are finished.
+func (c *credentials) replace(reloaded *credentials) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, session := range c.clients {
+		session.retire()
+	}
+
+	c.entries = reloaded.entries
+	c.passwords = reloaded.passwords
+	c.clients = reloaded.clients
+	c.kerberosConfig = reloaded.kerberosConfig
+}
+
+func (c *credentials) lookup(upstream *url.URL) *credential {
+	if upstream == nil || isSocks(upstream) {
+		return nil
+	}
+
+	c.mu.Lock()
+	entries := c.entries
+	c.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.matches(upstream) {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+// userinfo returns the username and the cached password of the credential for the upstream proxy.
+func (c *credentials) userinfo(entry *credential, upstream *url.URL) (*url.Userinfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	password, ok := c.passwords[entry]
+	if !ok {
+		var err error
+		if password, err = entry.readPassword(); err != nil {
+			return nil, fmt.Errorf("could not read password for upstream proxy %s: %w", upstream.Host, err)
+		}
+
+		c.passwords[entry] = password
+	}
+
+	return url.UserPassword(entry.Username, password), nil
+}
+
+// kerberosSession returns the kerberos session for the credential. The caller has to release it,
+// once the handshake is finished.
+func (c *credentials) kerberosSession(entry *credential) (*kerberosSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session, ok := c.clients[entry]
+	if !ok {
+		cl, err := kerberosClient(entry, c.kerberosConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		session = &kerberosSession{client: cl}
+		c.clients[entry] = session
+	}
+
+	session.acquire()
+	return session, nil
+}
+
+// reload reads the password for the upstream proxy again and reports, whether it changed. Kerberos
+// clients are always discarded, because the credential cache may have been renewed.
+func (c *credentials) reload(upstream *url.URL) (bool, error) {
+	entry := c.lookup(upstream)
+	if entry == nil {
+		return false, nil
+	}
+
+	if entry.Scheme == schemeNegotiate {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if session, ok := c.clients[entry]; ok {
+			session.retire()
+			delete(c.clients, entry)
+		}
+
+		return true, nil
+	}
+
+	password, err := entry.readPassword()
+	if err != nil {
+		return false, fmt.Errorf("could not read password for upstream proxy %s: %w", upstream.Host, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, ok := c.passwords[entry]
+	c.passwords[entry] = password
+
+	return !ok || previous != password, nil
+}
+
+// connectionBased reports whether the authentication with the upstream proxy is bound to the
+// connection, so requests cannot be forwarded using the pooled connections of a http.Transport.
+func (c *credentials) connectionBased(upstream *url.URL) bool {
+	entry := c.lookup(upstream)
+	return entry != nil && entry.Scheme != schemeBasic
+}
+
+// authenticate returns a copy of the upstream proxy including the username and password, which the
+// http.Transport sends as basic authentication.
+func (c *credentials) authenticate(upstream *url.URL) (*url.URL, error) {
+	entry := c.lookup(upstream)
+	if entry == nil || entry.Scheme != schemeBasic {
+		return upstream, nil
+	}
+
+	user, err := c.userinfo(entry, upstream)
+	if err != nil {
+		return upstream, err
+	}
+
+	authenticated := *upstream
+	authenticated.User = user
+
+	return &authenticated, nil
+}
+
+// handshake returns the handshake to authenticate a connection with the upstream proxy or nil, if
+// there are no credentials configured. It has to be passed to releaseHandshake afterwards.
+func (c *credentials) handshake(upstream *url.URL) (handshake, error) {
+	return c.handshakeWith(c.lookup(upstream), upstream)
+}
+
+// handshakeWith returns the handshake for the credential, that was looked up for the upstream proxy.
+func (c *credentials) handshakeWith(entry *credential, upstream *url.URL) (handshake, error) {
+	if entry == nil {
+		return nil, nil
+	}
+
+	if entry.Scheme == schemeNegotiate {
+		session, err := c.kerberosSession(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		return &negotiateHandshake{session: session, spn: "HTTP/" + upstream.Hostname()}, nil
+	}
+
+	user, err := c.userinfo(entry, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Scheme == schemeNTLM {
+		return &ntlmHandshake{domain: entry.Domain, user: user}, nil
+	}
+
+	return &basicHandshake{user: user}, nil
+}
+
+// kerberosSession counts the handshakes using a kerberos client, so the client is only destroyed
+// after the last of them finished.
+type kerberosSession struct {
+	client *client.Client
+
+	mu      sync.Mutex
+	users   int
+	retired bool
+}
+
+func (s *kerberosSession) acquire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users++
+}
+
+func (s *kerberosSession) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users--
+	s.destroyUnused()
+}
+
+// retire destroys the client, once it is no longer used. Retired sessions are not acquired again.
+func (s *kerberosSession) retire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retired = true
+	s.destroyUnused()
+}
+
+func (s *kerberosSession) destroyUnused() {
+	if s.retired && s.users == 0 {
+		s.client.Destroy()
+	}
+}
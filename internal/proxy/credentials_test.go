@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+// requireBasicAuth rejects requests, that do not authenticate with the username and password.
+func requireBasicAuth(next http.Handler, username, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != basicAuth(url.UserPassword(username, password)) {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="fake"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestCredentialMatches(t *testing.T) {
+	for _, tc := range []struct {
+		match    string
+		upstream string
+		matches  bool
+	}{
+		{"proxy.example.org:8080", "proxy.example.org:8080", true},
+		{"proxy.example.org:8080", "proxy.example.org:3128", false},
+		{"*.example.org", "PROXY.example.org:3128", true},
+		{"*.example.org:80*", "a.b.example.org:8080", true},
+		{"*.example.org", "example.org:8080", false},
+		{"*", "10.0.0.1:8080", true},
+	} {
+		c := credential{Match: tc.match, Username: "user"}
+		assert.NoError(t, c.compile())
+		assert.Equal(t, tc.matches, c.matches(&url.URL{Scheme: "proxy", Host: tc.upstream}), tc)
+	}
+
+	assert.Error(t, (&credential{Match: "*"}).compile())
+	assert.Error(t, (&credential{Match: "*", Username: "user", PasswordFile: "a", PasswordEnv: "b"}).compile())
+}
+
+func TestBasicAuth(t *testing.T) {
+	upstream := httptest.NewServer(requireBasicAuth(fakeUpstream(), "alice", "secret"))
+	defer upstream.Close()
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("outdated\n"), 0o600))
+
+	viper.Set("upstream.auth", `[
+		{"match": "other:8080", "username": "bob", "password_env": "UNSET"},
+		{"match": "127.0.0.1", "username": "alice", "password_file": "`+passwordFile+`"}
+	]`)
+	defer viper.Set("upstream.auth", "")
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+	t.Run("outdated", func(t *testing.T) {
+		res := get(t, proxy, serveHello(t).URL)
+		_ = res.Body.Close()
+
+		assert.Equal(t, http.StatusProxyAuthRequired, res.StatusCode)
+	})
+
+	// the password is read again after the upstream proxy rejects it
+	assert.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0o600))
+
+	t.Run("forward", func(t *testing.T) {
+		res := get(t, proxy, serveHello(t).URL)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+	})
+}
+
+func TestKerberosSessionRetire(t *testing.T) {
+	session := &kerberosSession{client: client.NewWithPassword("alice", "EXAMPLE.ORG", "secret", krb5config.New())}
+	destroyed := func() bool { return session.client.Credentials.UserName() == "" }
+
+	session.acquire()
+	session.retire()
+
+	// the running handshake keeps using the client
+	assert.False(t, destroyed())
+
+	session.release()
+	assert.True(t, destroyed())
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/match"
+)
+
+func init() {
+	config.Define("destination.default", config.String, actionAllow)
+	config.Define("destination.timeout.dns", config.Duration, "2s")
+	config.Define("destination.rules", config.Structure, "")
+	config.AddValidator(func() error {
+		_, err := destinationRulesFromEnv()
+		return err
+	})
+}
+
+const (
+	actionAllow = "allow"
+	actionDeny  = "deny"
+)
+
+// destinationRule matches requests by their destination. Every configured criterion has to match,
+// while any entry of a criterion is sufficient.
+type destinationRule struct {
+	match.Destination `mapstructure:",squash"`
+
+	Name    string   `mapstructure:"name"`
+	Action  string   `mapstructure:"action"`
+	Methods []string `mapstructure:"methods"`
+}
+
+func (d *destinationRule) compile() error {
+	switch d.Action = strings.ToLower(d.Action); d.Action {
+	case actionAllow, actionDeny:
+	default:
+		return fmt.Errorf("action must be %s or %s", actionAllow, actionDeny)
+	}
+
+	return d.Destination.Compile()
+}
+
+func (d *destinationRule) String() string {
+	if d.Name != "" {
+		return d.Name
+	}
+
+	return fmt.Sprintf("%s hosts=%v networks=%v ports=%v methods=%v",
+		d.Action, d.Hosts, d.Networks, d.Ports, d.Methods)
+}
+
+// matches reports whether the rule applies to the destination. An error is returned, if the
+// destination could not be resolved to check the networks.
+func (d *destinationRule) matches(dst *destination) (bool, error) {
+	if len(d.Methods) > 0 && !containsFold(d.Methods, dst.method) {
+		return false, nil
+	}
+
+	return d.Destination.Matches(dst.host, dst.port, dst.addrs)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// destination of a request. The host is only resolved, if a rule needs the addresses.
+type destination struct {
+	method string
+	host   string
+	port   int
+
+	ctx           context.Context
+	timeout       time.Duration
+	resolved      bool
+	resolvedAddrs []netip.Addr
+	resolveErr    error
+}
+
+func newDestination(r *http.Request, timeout time.Duration) *destination {
+	port, err := strconv.Atoi(r.URL.Port())
+	if err != nil {
+		port = 80
+		if r.URL.Scheme == "https" {
+			port = 443
+		}
+	}
+
+	return &destination{
+		method:  r.Method,
+		host:    strings.ToLower(strings.TrimSuffix(r.URL.Hostname(), ".")),
+		port:    port,
+		ctx:     r.Context(),
+		timeout: timeout,
+	}
+}
+
+func (d *destination) addrs() ([]netip.Addr, error) {
+	if d.resolved {
+		return d.resolvedAddrs, d.resolveErr
+	}
+
+	d.resolved = true
+
+	if addr, err := netip.ParseAddr(d.host); err == nil {
+		d.resolvedAddrs = []netip.Addr{addr.Unmap()}
+		return d.resolvedAddrs, nil
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", d.host)
+	if err != nil {
+		d.resolveErr = err
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		d.resolvedAddrs = append(d.resolvedAddrs, addr.Unmap())
+	}
+
+	return d.resolvedAddrs, nil
+}
+
+type destinationContextKey struct{}
+
+// checkedDestination holds the addresses, that the destination rules were checked against.
+type checkedDestination struct {
+	host  string
+	addrs []netip.Addr
+}
+
+// withDestination stores the checked addresses of the destination in the context of the request,
+// so that direct connections use them instead of resolving the host again.
+func withDestination(r *http.Request, dst *destination) *http.Request {
+	if len(dst.resolvedAddrs) == 0 {
+		return r
+	}
+
+	checked := &checkedDestination{host: dst.host, addrs: dst.resolvedAddrs}
+	return r.WithContext(context.WithValue(r.Context(), destinationContextKey{}, checked))
+}
+
+// dialDirect connects to addr. If addr refers to a checked destination, one of its checked
+// addresses is dialed, so that the host cannot resolve to a different address in the meantime.
+func (h *Handler) dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	checked, _ := ctx.Value(destinationContextKey{}).(*checkedDestination)
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || checked == nil || !strings.EqualFold(strings.TrimSuffix(host, "."), checked.host) {
+		return h.dialer.DialContext(ctx, network, addr)
+	}
+
+	var errs []error
+	for _, checkedAddr := range checked.addrs {
+		conn, err := h.dialer.DialContext(ctx, network, net.JoinHostPort(checkedAddr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// destinationRules decide whether a destination may be accessed. The first matching rule wins.
+type destinationRules struct {
+	rules      []*destinationRule
+	allowOther bool
+	timeout    time.Duration
+}
+
+// destinationRulesFromEnv returns nil, if there are no rules.
+func destinationRulesFromEnv() (*destinationRules, error) {
+	var rules []*destinationRule
+	if err := config.UnmarshalKey("destination.rules", &rules); err != nil {
+		return nil, fmt.Errorf("invalid destination.rules: %w", err)
+	}
+
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("invalid destination.rules[%d]: %w", i, err)
+		}
+	}
+
+	defaultAction := strings.ToLower(viper.GetString("destination.default"))
+	if defaultAction != actionAllow && defaultAction != actionDeny {
+		return nil, fmt.Errorf("invalid destination.default: must be %s or %s", actionAllow, actionDeny)
+	}
+
+	if len(rules) == 0 && defaultAction == actionAllow {
+		return nil, nil
+	}
+
+	return &destinationRules{
+		rules:      rules,
+		allowOther: defaultAction == actionAllow,
+		timeout:    viper.GetDuration("destination.timeout.dns"),
+	}, nil
+}
+
+// allowed reports whether the destination of the request may be accessed. Destinations, that
+// cannot be resolved to check a rule, are denied. The returned request carries the addresses the
+// rules were checked against.
+func (d *destinationRules) allowed(log *slog.Logger, r *http.Request) (*http.Request, bool) {
+	dst := newDestination(r, d.timeout)
+
+	for i, rule := range d.rules {
+		matches, err := rule.matches(dst)
+		if err == nil && !matches {
+			continue
+		}
+
+		log := log.With(slog.Int("rule", i), slog.String("name", rule.String()))
+
+		if err != nil {
+			log.Warn("destination denied, because it could not be resolved", slog.Any("err", err))
+			return r, false
+		}
+
+		if rule.Action == actionDeny {
+			log.Warn("destination denied by rule")
+			return r, false
+		}
+
+		log.Debug("destination allowed by rule")
+		return withDestination(r, dst), true
+	}
+
+	if !d.allowOther {
+		log.Warn("destination denied, because no rule matched")
+	}
+
+	return withDestination(r, dst), d.allowOther
+}
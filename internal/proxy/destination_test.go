@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationRules(t *testing.T) {
+	defer viper.Set("destination.rules", "")
+	defer viper.Set("destination.default", actionAllow)
+
+	viper.Set("destination.rules", `[
+		{"name": "metadata", "action": "deny", "networks": "169.254.0.0/16"},
+		{"name": "admin", "action": "deny", "hosts": ["*.admin.example.org"]},
+		{"name": "localhost", "action": "deny", "hosts": "localhost", "networks": "127.0.0.0/8"},
+		{"name": "tls", "action": "allow", "methods": "CONNECT", "ports": "443,8443-8444"},
+		{"action": "deny", "methods": ["CONNECT"]},
+		{"action": "allow", "methods": ["GET", "HEAD"]}
+	]`)
+	viper.Set("destination.default", "DENY")
+
+	rules, err := destinationRulesFromEnv()
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		method  string
+		url     string
+		allowed bool
+	}{
+		{http.MethodGet, "http://169.254.169.254/latest/meta-data", false},
+		{http.MethodGet, "http://[::ffff:169.254.169.254]/", false},
+		{http.MethodGet, "http://db.ADMIN.example.org/", false},
+		{http.MethodGet, "http://localhost:8080/", false},
+		{http.MethodGet, "http://192.0.2.1/", true},
+		{http.MethodPost, "http://192.0.2.1/", false},
+		{http.MethodConnect, "//192.0.2.1:443", true},
+		{http.MethodConnect, "//192.0.2.1:8444", true},
+		{http.MethodConnect, "//192.0.2.1:22", false},
+		{http.MethodConnect, "//127.0.0.1:443", true},
+		// the networks of the first rule cannot be checked
+		{http.MethodGet, "http://unresolvable.invalid/", false},
+	} {
+		u, err := url.Parse(tc.url)
+		assert.NoError(t, err)
+
+		r := &http.Request{Method: tc.method, URL: u}
+		_, allowed := rules.allowed(slog.Default(), r.WithContext(t.Context()))
+		assert.Equal(t, tc.allowed, allowed, tc)
+	}
+}
+
+func TestDialCheckedDestination(t *testing.T) {
+	_, port, err := net.SplitHostPort(serveEcho(t).Addr().String())
+	assert.NoError(t, err)
+
+	checked := &checkedDestination{host: "checked.invalid", addrs: []netip.Addr{netip.MustParseAddr("127.0.0.1")}}
+	ctx := context.WithValue(t.Context(), destinationContextKey{}, checked)
+
+	var handler Handler
+
+	conn, err := handler.dialDirect(ctx, "tcp", net.JoinHostPort("checked.invalid", port))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	// other hosts are resolved as usual
+	_, err = handler.dialDirect(ctx, "tcp", net.JoinHostPort("other.invalid", port))
+	assert.Error(t, err)
+}
+
+func TestDestinationRulesInvalid(t *testing.T) {
+	defer viper.Set("destination.rules", "")
+
+	for _, rules := range []string{
+		`[{"action": "maybe"}]`,
+		`[{"action": "deny", "ports": "70000"}]`,
+		`[{"action": "deny", "ports": "90-80"}]`,
+		`[{"action": "deny", "networks": "10.0.0.0/33"}]`,
+	} {
+		viper.Set("destination.rules", rules)
+
+		_, err := destinationRulesFromEnv()
+		assert.Error(t, err, rules)
+	}
+}
+
+func TestDestinationDenied(t *testing.T) {
+	defer viper.Set("destination.rules", "")
+	viper.Set("destination.rules", `[{"action": "deny", "methods": "CONNECT", "ports": "1-442,444-65535"}]`)
+
+	proxy := newProxy(t, pacSource("DIRECT"))
+
+	assert.EqualError(t,
+		connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()),
+		"unexpected status 403 Forbidden")
+
+	res := get(t, proxy, serveHello(t).URL)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
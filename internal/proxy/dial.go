@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/socks"
+)
+
+func init() {
+	config.Define("upstream.socks.username", config.String, "")
+	config.Define("upstream.socks.password", config.Secret, "")
+	config.Define("upstream.socks.dns.remote", config.Bool, true)
+}
+
+var (
+	// socksVersions maps the pac proxy types to socks versions. A plain "SOCKS" is treated as
+	// SOCKS4, like browsers do.
+	socksVersions = map[string]int{
+		"socks":  socks.Version4,
+		"socks4": socks.Version4,
+		"socks5": socks.Version5,
+	}
+)
+
+type upstreamContextKey struct{}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// withUpstream stores the resolved upstream proxy of a request in its context.
+func withUpstream(r *http.Request, upstream *url.URL) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), upstreamContextKey{}, upstream))
+}
+
+// upstreamFromRequest returns the upstream proxy stored by withUpstream.
+func upstreamFromRequest(r *http.Request) (*url.URL, error) {
+	upstream, _ := r.Context().Value(upstreamContextKey{}).(*url.URL)
+	return upstream, nil
+}
+
+func isSocks(upstream *url.URL) bool {
+	if upstream == nil {
+		return false
+	}
+
+	_, ok := socksVersions[upstream.Scheme]
+	return ok
+}
+
+// socksSettings configure the connections to socks upstream proxies.
+type socksSettings struct {
+	username  string
+	password  string
+	remoteDNS bool
+}
+
+func socksSettingsFromEnv() *socksSettings {
+	return &socksSettings{
+		username:  viper.GetString("upstream.socks.username"),
+		password:  viper.GetString("upstream.socks.password"),
+		remoteDNS: viper.GetBool("upstream.socks.dns.remote"),
+	}
+}
+
+func (h *Handler) socksDialer(upstream *url.URL) *socks.Dialer {
+	settings := h.socks.Load()
+
+	return &socks.Dialer{
+		Version:   socksVersions[upstream.Scheme],
+		Addr:      upstream.Host,
+		Username:  settings.username,
+		Password:  settings.password,
+		RemoteDNS: settings.remoteDNS,
+		Forward:   &h.dialer,
+		// the handshake is limited like the CONNECT request to http upstream proxies
+		Timeout: h.dialer.Timeout,
+	}
+}
+
+func isTLS(upstream *url.URL) bool {
+	return upstream != nil && upstream.Scheme == "https"
+}
+
+// dial connects to addr directly or through a socks upstream proxy. For http upstream proxies it
+// connects to the proxy itself, speaking tls for https proxies, and the caller is responsible for
+// sending the request.
+func (h *Handler) dial(ctx context.Context, upstream *url.URL, addr string) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	switch {
+	case isSocks(upstream):
+		conn, err = h.socksDialer(upstream).DialContext(ctx, "tcp", addr)
+
+	case isTLS(upstream):
+		conn, err = h.dialTLS(ctx, upstream)
+
+	case upstream != nil:
+		conn, err = h.dialer.DialContext(ctx, "tcp", upstream.Host)
+
+	default:
+		conn, err = h.dialDirect(ctx, "tcp", addr)
+	}
+
+	if err != nil {
+		return nil, &dialError{err}
+	}
+
+	return conn, nil
+}
+
+// wrapDialError marks all errors of dial as dialError.
+func wrapDialError(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, &dialError{err}
+		}
+
+		return conn, nil
+	}
+}
+
+// transport returns the http.Transport to forward requests through the upstream proxy.
+func (h *Handler) transport(upstream *url.URL) *http.Transport {
+	if !isSocks(upstream) && !isTLS(upstream) {
+		return h.rt
+	}
+
+	key := upstream.String()
+	if rt, ok := h.transports.Load(key); ok {
+		return rt.(*http.Transport)
+	}
+
+	var rt http.Transport
+	if isSocks(upstream) {
+		rt.DialContext = wrapDialError(h.socksDialer(upstream).DialContext)
+	} else {
+		// The proxy is the first hop, so the custom tls dialer is only used to connect to the proxy.
+		rt.Proxy = upstreamFromRequest
+		rt.DialTLSContext = wrapDialError(func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return h.dialTLS(ctx, upstream)
+		})
+	}
+
+	actual, _ := h.transports.LoadOrStore(key, &rt)
+	return actual.(*http.Transport)
+}
+
+// flushTransports discards the transports, so that the next requests use the current settings.
+func (h *Handler) flushTransports() {
+	h.transports.Range(func(key, rt any) bool {
+		h.transports.Delete(key)
+		rt.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+}
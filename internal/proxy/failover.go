@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
+)
+
+func init() {
+	config.Define("upstream.timeout.dial", config.Duration, "10s")
+	config.Define("upstream.failover.backoff", config.Duration, "5m")
+}
+
+// dialError marks errors that happened while connecting to an upstream proxy or target, before any
+// data of the request was sent. Requests failing with a dialError can be retried safely.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+func isDialError(err error) bool {
+	var dialErr *dialError
+	return errors.As(err, &dialErr)
+}
+
+// failover calls attempt for each candidate in order, until one does not fail with a dialError.
+// Recently failed upstream proxies are tried last. The request is counted once with the decision of
+// the last attempted candidate.
+func (h *Handler) failover(log *slog.Logger, r *http.Request, candidates []*url.URL, attempt func(*slog.Logger, *url.URL) error) error {
+	var (
+		err      error
+		decision string
+		rec      = recordFromRequest(r)
+	)
+
+	for _, upstream := range h.backoff.order(candidates) {
+		log := log
+		decision = metrics.DecisionDirect
+
+		if upstream != nil {
+			log = log.With(slog.Any("upstream", upstream))
+			decision = metrics.DecisionUpstream
+		}
+
+		rec.upstream, rec.forwarded = upstream, true
+
+		err = attempt(log, upstream)
+		countUpstreamError(upstream, err)
+
+		if !isDialError(err) {
+			if err == nil {
+				h.backoff.markSucceeded(upstream)
+			}
+
+			break
+		}
+
+		log.Warn("could not connect, trying next candidate", slog.Any("err", err))
+
+		// only upstream proxies, that could not be reached, are marked as failed
+		var refused *tunnelError
+		if !errors.As(err, &refused) {
+			h.backoff.markFailed(upstream)
+		}
+	}
+
+	if decision != "" {
+		metrics.Requests.WithLabelValues(r.Method, decision).Inc()
+	}
+
+	return err
+}
+
+func countUpstreamError(upstream *url.URL, err error) {
+	var refused *tunnelError
+	if upstream != nil && (isDialError(err) || errors.As(err, &refused)) {
+		metrics.UpstreamErrors.WithLabelValues(upstream.Host).Inc()
+	}
+}
+
+// backoff remembers upstream proxies that failed recently, so they are tried last, like browsers do.
+type backoff struct {
+	mu       sync.Mutex
+	failed   map[string]time.Time
+	duration time.Duration
+}
+
+func newBackoff(duration time.Duration) *backoff {
+	return &backoff{
+		failed:   make(map[string]time.Time),
+		duration: duration,
+	}
+}
+
+// order returns the candidates with recently failed upstream proxies moved to the end.
+func (b *backoff) order(candidates []*url.URL) []*url.URL {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var (
+		healthy = make([]*url.URL, 0, len(candidates))
+		failed  []*url.URL
+	)
+
+	for _, candidate := range candidates {
+		if b.isFailed(candidate) {
+			failed = append(failed, candidate)
+		} else {
+			healthy = append(healthy, candidate)
+		}
+	}
+
+	return append(healthy, failed...)
+}
+
+func (b *backoff) isFailed(upstream *url.URL) bool {
+	if upstream == nil {
+		return false
+	}
+
+	key := upstream.String()
+
+	failedAt, ok := b.failed[key]
+	if ok && time.Since(failedAt) > b.duration {
+		delete(b.failed, key)
+		return false
+	}
+
+	return ok
+}
+
+func (b *backoff) markFailed(upstream *url.URL) {
+	if upstream == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	slog.Info("marking upstream proxy as failed",
+		slog.Any("upstream", upstream),
+		slog.Duration("backoff", b.duration),
+	)
+
+	b.failed[upstream.String()] = time.Now()
+}
+
+func (b *backoff) markSucceeded(upstream *url.URL) {
+	if upstream == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.failed, upstream.String())
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+// closedAddr returns an address, that refuses connections.
+func closedAddr(t testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	addr := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+
+	return addr
+}
+
+func TestFailover(t *testing.T) {
+	upstream := httptest.NewServer(fakeUpstream())
+	defer upstream.Close()
+
+	proxy := newProxy(t, pacSource("PROXY "+closedAddr(t)+"; PROXY "+upstream.Listener.Addr().String()))
+
+	t.Run("connect", func(t *testing.T) {
+		assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		res := get(t, proxy, serveHello(t).URL)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, "fake-upstream", res.Header.Get("Via"))
+	})
+}
+
+func TestBackoffOrder(t *testing.T) {
+	var (
+		a = &url.URL{Scheme: "proxy", Host: "a:8080"}
+		b = &url.URL{Scheme: "proxy", Host: "b:8080"}
+	)
+
+	backoff := newBackoff(time.Hour)
+	assert.Equal(t, []*url.URL{a, b, nil}, backoff.order([]*url.URL{a, b, nil}))
+
+	backoff.markFailed(a)
+	assert.Equal(t, []*url.URL{b, nil, a}, backoff.order([]*url.URL{a, b, nil}))
+
+	backoff.markSucceeded(a)
+	assert.Equal(t, []*url.URL{a, b, nil}, backoff.order([]*url.URL{a, b, nil}))
+
+	expired := newBackoff(0)
+	expired.markFailed(a)
+	time.Sleep(time.Millisecond)
+	assert.Equal(t, []*url.URL{a, b}, expired.order([]*url.URL{a, b}))
+}
+
+func TestFailoverCredentialsError(t *testing.T) {
+	upstream := httptest.NewServer(fakeUpstream())
+	defer upstream.Close()
+
+	viper.Set("upstream.auth", `[{"match": "127.0.0.1", "scheme": "ntlm", "username": "alice", "password_env": "UNSET"}]`)
+	defer viper.Set("upstream.auth", "")
+
+	resolver, err := pac.FromSource([]byte(pacSource("PROXY " + upstream.Listener.Addr().String() + "; DIRECT")))
+	assert.NoError(t, err)
+
+	handler, err := New(resolver)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	// the misconfigured credentials must not bypass the upstream proxy
+	assert.Error(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+
+	upstreamURL := &url.URL{Scheme: "proxy", Host: upstream.Listener.Addr().String()}
+	assert.Equal(t, []*url.URL{upstreamURL, nil}, handler.backoff.order([]*url.URL{upstreamURL, nil}))
+}
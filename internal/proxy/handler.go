@@ -1,12 +1,19 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 
-	"github.com/rs/xid"
+	"github.com/spf13/viper"
 
+	"github.com/lukasdietrich/proxyproxy/internal/accesslog"
 	"github.com/lukasdietrich/proxyproxy/internal/cache"
 	"github.com/lukasdietrich/proxyproxy/internal/pac"
 )
@@ -15,11 +22,38 @@ var (
 	_ http.Handler = &Handler{}
 )
 
-type resolveRequestProxyFunc func(*http.Request) (*url.URL, error)
-type resolveUrlProxyFunc func(*url.URL) (*url.URL, error)
+type resolveRequestProxyFunc func(*http.Request) ([]*url.URL, error)
+type resolveUrlProxyFunc func(*url.URL) ([]*url.URL, error)
 
 type Handler struct {
+	resolve resolveRequestProxyFunc
+	dialer  net.Dialer
+	// rt forwards requests directly or through http upstream proxies.
 	rt *http.Transport
+	// transports forward requests through socks or https upstream proxies, one per upstream.
+	transports sync.Map
+	socks      atomic.Pointer[socksSettings]
+	// tlsConfig is used to connect to https upstream proxies.
+	tlsConfig *tls.Config
+	// credentials authenticate with upstream proxies.
+	credentials *credentials
+	// conns keeps connections authenticated by connection based schemes like ntlm.
+	conns *connPool
+	// accessList restricts the client addresses, if enabled.
+	accessList atomic.Pointer[accessList]
+	// clientAuth authenticates clients of proxyproxy, if enabled.
+	clientAuth atomic.Pointer[clientAuth]
+	// destinationRules restrict the destinations, if enabled.
+	destinationRules atomic.Pointer[destinationRules]
+	backoff          *backoff
+	// local serves requests addressed to proxyproxy itself instead of a target.
+	local *http.ServeMux
+
+	upstream *pac.Config
+	cache    *cache.Func[*url.URL, []*url.URL]
+	tunnels  tunnels
+	// accessLog writes an entry per completed request, if enabled.
+	accessLog *accesslog.Logger
 }
 
 func FromEnv() (*Handler, error) {
@@ -28,20 +62,97 @@ func FromEnv() (*Handler, error) {
 		return nil, err
 	}
 
-	return New(pac), nil
+	return New(pac)
 }
 
-func New(upstream *pac.Config) *Handler {
-	return &Handler{
-		rt: &http.Transport{
-			Proxy: wrapResolveRequestProxyFunc(cache.Func(upstream.Resolve)),
+func New(upstream *pac.Config) (*Handler, error) {
+	tlsConfig, err := upstreamTLSConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := credentialsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	accessList, err := accessListFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := clientAuthFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	destinationRules, err := destinationRulesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	pacFile, err := pacFileFromEnv(upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	accessLog, err := accesslog.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := cache.NewFunc(func(requestUrl *url.URL) ([]*url.URL, error) {
+		candidates, cacheable, err := upstream.ResolveCacheable(requestUrl)
+		if err == nil && !cacheable {
+			return candidates, cache.ErrUncached
+		}
+
+		return candidates, err
+	})
+	upstream.OnChange(resolve.Flush)
+
+	handler := Handler{
+		resolve: wrapResolveRequestProxyFunc(resolve.Call),
+		dialer: net.Dialer{
+			Timeout: viper.GetDuration("upstream.timeout.dial"),
 		},
+		tlsConfig:   tlsConfig,
+		credentials: credentials,
+		conns:       newConnPool(),
+		backoff:     newBackoff(viper.GetDuration("upstream.failover.backoff")),
+		local:       http.NewServeMux(),
+		upstream:    upstream,
+		cache:       resolve,
+		accessLog:   accessLog,
 	}
+
+	handler.socks.Store(socksSettingsFromEnv())
+	handler.accessList.Store(accessList)
+	handler.clientAuth.Store(clientAuth)
+	handler.destinationRules.Store(destinationRules)
+
+	if pacFile != nil {
+		handler.local.Handle("GET /proxy.pac", pacFile)
+		handler.local.Handle("GET /wpad.dat", pacFile)
+	}
+
+	handler.rt = &http.Transport{
+		Proxy:       upstreamFromRequest,
+		DialContext: wrapDialError(handler.dialDirect),
+	}
+
+	return &handler, nil
 }
 
 func wrapResolveRequestProxyFunc(resolve resolveUrlProxyFunc) resolveRequestProxyFunc {
-	return func(r *http.Request) (*url.URL, error) {
+	return func(r *http.Request) ([]*url.URL, error) {
 		strippedUrl := stripUrl(r.URL)
+
+		// CONNECT requests carry no scheme, but tunnel tls like browsers assume
+		if r.Method == http.MethodConnect && strippedUrl.Scheme == "" {
+			strippedUrl.Scheme = "https"
+		}
+
 		return resolve(strippedUrl)
 	}
 }
@@ -54,18 +165,106 @@ func stripUrl(u *url.URL) *url.URL {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := newRecord()
+	r = withRecord(r, rec)
+	w = &recordingWriter{ResponseWriter: w, rec: rec}
+
+	if h.accessLog != nil {
+		defer func() { h.accessLog.Log(rec.entry(r)) }()
+	}
+
 	log := slog.With(slog.Group("request",
-		slog.Any("id", xid.New()),
+		slog.Any("id", rec.id),
 		slog.String("method", r.Method),
 		slog.Any("url", r.URL),
 	))
 
+	if accessList := h.accessList.Load(); accessList != nil && !accessList.allowed(r) {
+		log.Warn("client address is not allowed", slog.String("client", r.RemoteAddr))
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	// Requests in origin-form are not meant to be proxied. Clients fetching the pac cannot
+	// authenticate, so these requests are served before.
+	if r.URL.Host == "" {
+		log.Debug("serving local request")
+		h.local.ServeHTTP(w, r)
+		return
+	}
+
+	if clientAuth := h.clientAuth.Load(); clientAuth != nil {
+		username, ok := clientAuth.authenticate(r)
+		if !ok {
+			log.Info("client is not authenticated", slog.String("client", r.RemoteAddr))
+			clientAuth.challenge(w)
+			return
+		}
+
+		if username != "" {
+			log = log.With(slog.String("user", username))
+			rec.user = username
+		}
+	}
+
+	// the credentials of the client are never forwarded
+	r.Header.Del("Proxy-Authorization")
+
+	if destinationRules := h.destinationRules.Load(); destinationRules != nil {
+		var allowed bool
+		if r, allowed = destinationRules.allowed(log, r); !allowed {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
 	if err := h.handle(log, w, r); err != nil {
+		if errors.Is(err, pac.ErrEvaluationAborted) {
+			log.Error("could not resolve upstream proxy", slog.Any("err", err))
+			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+			return
+		}
+
+		if errors.Is(err, errDraining) {
+			log.Warn("could not establish tunnel", slog.Any("err", err))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		if errors.Is(err, pac.ErrBlocked) {
+			log.Warn("destination blocked", slog.Any("err", err))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		var refused *tunnelError
+		if errors.As(err, &refused) {
+			log.Warn("could not establish tunnel", slog.Any("err", err))
+			http.Error(w, http.StatusText(refused.statusCode()), refused.statusCode())
+			return
+		}
+
 		log.Warn("could not proxy request", slog.Any("err", err))
 		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 	}
 }
 
+// Shutdown waits for the established tunnels to be closed, which the http server does not track.
+// When the context is done, the remaining tunnels are closed forcibly and the error of the context
+// is returned. The access log is closed afterwards.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	err := h.tunnels.drain(ctx)
+	h.conns.flush()
+
+	if h.accessLog != nil {
+		if closeErr := h.accessLog.Close(); closeErr != nil {
+			slog.Warn("could not close access log", slog.Any("err", closeErr))
+		}
+	}
+
+	return err
+}
+
 func (h *Handler) handle(log *slog.Logger, w http.ResponseWriter, r *http.Request) error {
 	if r.Method == http.MethodConnect {
 		return h.proxyHttps(log, w, r)
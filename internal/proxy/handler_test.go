@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+// newProxy starts proxyproxy using the pac source.
+func newProxy(t testing.TB, source string) *httptest.Server {
+	upstream, err := pac.FromSource([]byte(source))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	t.Cleanup(proxy.Close)
+
+	return proxy
+}
+
+// pacSource returns a pac, that always returns the target.
+func pacSource(target string) string {
+	return fmt.Sprintf(`function FindProxyForURL(url, host) { return %q; }`, target)
+}
+
+// fakeUpstream is a minimal http proxy, that tunnels CONNECT requests and forwards everything else.
+func fakeUpstream() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			client, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+
+			_, _ = io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+			go func() {
+				_, _ = io.Copy(target, client)
+				_ = target.Close()
+			}()
+
+			_, _ = io.Copy(client, target)
+			_ = client.Close()
+			return
+		}
+
+		r.RequestURI = ""
+
+		res, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		w.Header().Set("Via", "fake-upstream")
+		w.WriteHeader(res.StatusCode)
+		_, _ = io.Copy(w, res.Body)
+	})
+}
+
+// serveHello starts a http server, that responds with "hello".
+func serveHello(t testing.TB) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// get requests the url through the proxy.
+func get(t testing.TB, proxy *httptest.Server, target string) *http.Response {
+	proxyUrl, err := url.Parse(proxy.URL)
+	assert.NoError(t, err)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyUrl),
+		},
+	}
+
+	res, err := client.Get(target)
+	assert.NoError(t, err)
+
+	return res
+}
+
+func TestTLSUpstream(t *testing.T) {
+	upstream := httptest.NewTLSServer(fakeUpstream())
+	defer upstream.Close()
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: upstream.Certificate().Raw,
+	}), 0o644))
+
+	viper.Set("upstream.tls.ca", ca)
+	defer viper.Set("upstream.tls.ca", "")
+
+	proxy := newProxy(t, pacSource("HTTPS "+upstream.Listener.Addr().String()))
+
+	t.Run("connect", func(t *testing.T) {
+		assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+	})
+
+	t.Run("forward", func(t *testing.T) {
+		res := get(t, proxy, serveHello(t).URL)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, "fake-upstream", res.Header.Get("Via"))
+	})
+}
+
+func TestTLSUpstreamUntrusted(t *testing.T) {
+	upstream := httptest.NewTLSServer(fakeUpstream())
+	defer upstream.Close()
+
+	proxy := newProxy(t, pacSource("HTTPS "+upstream.Listener.Addr().String()))
+
+	res := get(t, proxy, serveHello(t).URL)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+}
+
+func TestFallbackIsNotCached(t *testing.T) {
+	defer viper.Set("pac.fallback", viper.GetString("pac.fallback"))
+	defer viper.Set("pac.timeout.evaluation", viper.GetString("pac.timeout.evaluation"))
+
+	viper.Set("pac.fallback", "DIRECT")
+	viper.Set("pac.timeout.evaluation", "50ms")
+
+	upstream, err := pac.FromSource([]byte(`function FindProxyForURL(url, host) { while (true) {} }`))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	res := get(t, proxy, serveHello(t).URL)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, handler.cache.Entries())
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/lukasdietrich/proxyproxy/internal/ntlm"
+)
+
+// handshake authenticates requests with an upstream proxy. Some schemes like ntlm need multiple
+// requests and authenticate the connection instead of a single request.
+type handshake interface {
+	// next returns the Proxy-Authorization header answering the Proxy-Authenticate challenges of
+	// the upstream proxy, which are nil for the first request. An empty authorization sends the
+	// request without the header. done reports whether this is the last request of the handshake.
+	next(challenges []string) (authorization string, done bool, err error)
+}
+
+// handshakeError marks errors of a handshake, that are not caused by the connection to the upstream
+// proxy. Trying the next candidate would bypass the upstream proxy, so they are not retried.
+type handshakeError struct {
+	err error
+}
+
+func (e *handshakeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+func isHandshakeError(err error) bool {
+	var handshakeErr *handshakeError
+	return errors.As(err, &handshakeErr)
+}
+
+// releaseHandshake frees the resources held by the handshake, once it is finished.
+func releaseHandshake(hs handshake) {
+	if r, ok := hs.(interface{ release() }); ok {
+		r.release()
+	}
+}
+
+type basicHandshake struct {
+	user *url.Userinfo
+}
+
+func (h *basicHandshake) next([]string) (string, bool, error) {
+	return basicAuth(h.user), true, nil
+}
+
+func basicAuth(user *url.Userinfo) string {
+	password, _ := user.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+}
+
+// ntlmHandshake implements NTLMv2 using the negotiate and authenticate messages.
+type ntlmHandshake struct {
+	domain string
+	user   *url.Userinfo
+}
+
+func (h *ntlmHandshake) next(challenges []string) (string, bool, error) {
+	if challenges == nil {
+		return "NTLM " + base64.StdEncoding.EncodeToString(ntlm.Negotiate()), false, nil
+	}
+
+	challenge, err := findChallenge(challenges, "NTLM")
+	if err != nil {
+		return "", false, err
+	}
+
+	password, _ := h.user.Password()
+
+	message, err := ntlm.Authenticate(challenge, h.domain, h.user.Username(), password)
+	if err != nil {
+		return "", false, err
+	}
+
+	return "NTLM " + base64.StdEncoding.EncodeToString(message), true, nil
+}
+
+// hasChallenge reports whether the upstream proxy offers the scheme, with or without a token.
+func hasChallenge(challenges []string, scheme string) bool {
+	for _, challenge := range challenges {
+		if name, _, _ := strings.Cut(challenge, " "); strings.EqualFold(name, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findChallenge returns the decoded token of the Proxy-Authenticate challenge for the scheme.
+func findChallenge(challenges []string, scheme string) ([]byte, error) {
+	for _, challenge := range challenges {
+		name, token, _ := strings.Cut(challenge, " ")
+		if strings.EqualFold(name, scheme) && token != "" {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+		}
+	}
+
+	return nil, fmt.Errorf("upstream proxy did not send a %s challenge", scheme)
+}
+
+// exchange sends the request to the upstream proxy over the connection and reads the response.
+// Requests of a handshake, except for the last one, are sent without body and the response is
+// discarded, so the connection can be reused for the next request.
+func exchange(conn net.Conn, reader *bufio.Reader, r *http.Request, hs handshake) (*http.Response, error) {
+	var challenges []string
+
+	for {
+		req := r
+		done := true
+
+		if hs != nil {
+			var (
+				authorization string
+				err           error
+			)
+
+			if authorization, done, err = hs.next(challenges); err != nil {
+				return nil, &handshakeError{err}
+			}
+
+			req = r.Clone(r.Context())
+			if authorization != "" {
+				req.Header.Set("Proxy-Authorization", authorization)
+			}
+
+			if !done {
+				req.Body = http.NoBody
+				req.ContentLength = 0
+			}
+		}
+
+		if err := writeRequest(conn, req); err != nil {
+			return nil, err
+		}
+
+		res, err := http.ReadResponse(reader, req)
+		if err != nil {
+			return nil, err
+		}
+
+		if done || res.StatusCode != http.StatusProxyAuthRequired {
+			return res, nil
+		}
+
+		// never nil, which marks the first request
+		challenges = append([]string{}, res.Header.Values("Proxy-Authenticate")...)
+
+		_, err = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Close {
+			return nil, fmt.Errorf("upstream proxy closed the connection during the handshake")
+		}
+	}
+}
+
+// writeRequest writes CONNECT requests in authority form and everything else in absolute form.
+func writeRequest(w io.Writer, r *http.Request) error {
+	if r.Method == http.MethodConnect {
+		return r.Write(w)
+	}
+
+	return r.WriteProxy(w)
+}
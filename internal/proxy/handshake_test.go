@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/ntlm/ntlmtest"
+)
+
+type ntlmStateKey struct{}
+
+// ntlmState is the state of the handshake for a single connection.
+type ntlmState struct {
+	challenge     []byte
+	authenticated bool
+}
+
+// fakeNTLMUpstream is a proxy, that requires NTLMv2 authentication for each connection.
+func fakeNTLMUpstream(t testing.TB, next http.Handler, password string) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := r.Context().Value(ntlmStateKey{}).(*ntlmState)
+
+		if state.authenticated {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "NTLM ")
+		message, err := base64.StdEncoding.DecodeString(token)
+
+		switch {
+		case !ok || err != nil || len(message) < 12:
+			w.Header().Set("Proxy-Authenticate", "NTLM")
+
+		case message[8] == 1:
+			state.challenge = ntlmtest.Challenge([8]byte{1, 2, 3, 4, 5, 6, 7, 8})
+			w.Header().Set("Proxy-Authenticate", "NTLM "+base64.StdEncoding.EncodeToString(state.challenge))
+
+		case message[8] == 3 && state.challenge != nil:
+			if _, _, err := ntlmtest.Verify(state.challenge, message, password); err == nil {
+				state.authenticated = true
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// handshake requests must not carry the body
+		if n, _ := io.Copy(io.Discard, r.Body); n > 0 {
+			t.Errorf("unexpected body of %d bytes during handshake", n)
+		}
+
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+
+	server.Config.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, ntlmStateKey{}, &ntlmState{})
+	}
+
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestNTLM(t *testing.T) {
+	upstream := fakeNTLMUpstream(t, fakeUpstream(), "secret")
+
+	t.Setenv("NTLM_PASSWORD", "secret")
+
+	viper.Set("upstream.auth", `[
+		{"match": "*", "scheme": "ntlm", "username": "CORP\\alice", "password_env": "NTLM_PASSWORD"}
+	]`)
+	defer viper.Set("upstream.auth", "")
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+	t.Run("forward", func(t *testing.T) {
+		res := get(t, proxy, serveHello(t).URL)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.Equal(t, "fake-upstream", res.Header.Get("Via"))
+	})
+
+	t.Run("post", func(t *testing.T) {
+		echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(w, r.Body)
+		}))
+		defer echo.Close()
+
+		proxyUrl, err := url.Parse(proxy.URL)
+		assert.NoError(t, err)
+
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+
+		res, err := client.Post(echo.URL, "text/plain", strings.NewReader("payload"))
+		assert.NoError(t, err)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "payload", string(body))
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+	})
+}
+
+func TestNTLMWrongPassword(t *testing.T) {
+	upstream := fakeNTLMUpstream(t, fakeUpstream(), "secret")
+
+	t.Setenv("NTLM_PASSWORD", "wrong")
+
+	viper.Set("upstream.auth", `[{"match": "*", "scheme": "ntlm", "username": "alice", "password_env": "NTLM_PASSWORD"}]`)
+	defer viper.Set("upstream.auth", "")
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+	res := get(t, proxy, serveHello(t).URL)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusProxyAuthRequired, res.StatusCode)
+}
+
+func TestNTLMConnectionReuse(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		clients = make(map[string]bool)
+	)
+
+	upstream := fakeNTLMUpstream(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		clients[r.RemoteAddr] = true
+		mu.Unlock()
+
+		fakeUpstream().ServeHTTP(w, r)
+	}), "secret")
+
+	t.Setenv("NTLM_PASSWORD", "secret")
+
+	viper.Set("upstream.auth", `[{"match": "*", "scheme": "ntlm", "username": "alice", "password_env": "NTLM_PASSWORD"}]`)
+	defer viper.Set("upstream.auth", "")
+
+	proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+	target := serveHello(t).URL
+
+	for range 3 {
+		res := get(t, proxy, target)
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.NoError(t, res.Body.Close())
+	}
+
+	// the authenticated connection is reused without another handshake
+	assert.Len(t, clients, 1)
+}
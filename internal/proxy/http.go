@@ -1,13 +1,23 @@
 package proxy
 
 import (
+	"bufio"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"slices"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
 )
 
 var (
+	bytesIn  = metrics.TransferredBytes.WithLabelValues(metrics.DirectionIn)
+	bytesOut = metrics.TransferredBytes.WithLabelValues(metrics.DirectionOut)
+
 	proxyHeaders = []string{
 		"Proxy-Connection",
 		"Proxy-Authorization",
@@ -18,17 +28,126 @@ func (h *Handler) proxyHttp(log *slog.Logger, w http.ResponseWriter, r *http.Req
 	log.Debug("clearing proxy headers")
 	clearProxyHeaders(r)
 
-	log.Debug("forwarding request via http")
-	res, err := h.rt.RoundTrip(r)
+	candidates, err := h.resolve(r)
 	if err != nil {
 		return err
 	}
 
-	//nolint:errcheck
-	defer res.Body.Close()
+	// The transport closes the body on errors, but it is still needed if the next candidate is
+	// tried. The server closes the original body anyway.
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = io.NopCloser(&countingReader{Reader: r.Body, counter: bytesOut, n: &recordFromRequest(r).bytesOut})
+	}
+
+	return h.failover(log, r, candidates, func(log *slog.Logger, upstream *url.URL) error {
+		log.Debug("forwarding request via http")
+		res, err := h.roundTrip(log, r, upstream)
+		if err != nil {
+			return err
+		}
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		log.Debug("copying response")
+		return copyResponse(log, w, res)
+	})
+}
+
+// roundTrip forwards the request through the upstream proxy. If the upstream proxy rejects the
+// credentials, the password is read again and requests without a body are retried once.
+func (h *Handler) roundTrip(log *slog.Logger, r *http.Request, upstream *url.URL) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var (
+			res *http.Response
+			err error
+		)
+
+		if h.credentials.connectionBased(upstream) {
+			res, err = h.roundTripConn(r, upstream)
+		} else {
+			var authenticated *url.URL
+			if authenticated, err = h.credentials.authenticate(upstream); err == nil {
+				res, err = h.transport(upstream).RoundTrip(withUpstream(r, authenticated))
+			}
+		}
+
+		if err != nil || res.StatusCode != http.StatusProxyAuthRequired || attempt > 0 || r.ContentLength != 0 {
+			return res, err
+		}
 
-	log.Debug("copying response")
-	return copyResponse(log, w, res)
+		changed, err := h.credentials.reload(upstream)
+		if err != nil || !changed {
+			return res, err
+		}
+
+		//nolint:errcheck
+		res.Body.Close()
+
+		log.Info("upstream proxy rejected the credentials, retrying with reloaded credentials")
+	}
+}
+
+// roundTripConn forwards the request on a dedicated connection to the upstream proxy, because the
+// authentication is bound to the connection the handshake happened on. Authenticated connections
+// are pooled and reused without another handshake.
+func (h *Handler) roundTripConn(r *http.Request, upstream *url.URL) (*http.Response, error) {
+	entry := h.credentials.lookup(upstream)
+	key := connKey{upstream: upstream.String(), credential: entry}
+
+	if pc := h.conns.get(key); pc != nil {
+		res, err := exchange(pc.conn, pc.reader, r, nil)
+		if err == nil && res.StatusCode != http.StatusProxyAuthRequired {
+			res.Body = &pooledBody{ReadCloser: res.Body, pool: h.conns, key: key, conn: pc, reusable: !res.Close}
+			return res, nil
+		}
+
+		// The upstream proxy may have closed the idle connection or expired its authentication.
+		// Requests with a body cannot be sent again.
+		if hasBody(r) {
+			if err != nil {
+				_ = pc.conn.Close()
+				return nil, err
+			}
+
+			res.Body = &pooledBody{ReadCloser: res.Body, pool: h.conns, key: key, conn: pc}
+			return res, nil
+		}
+
+		if err == nil {
+			_ = res.Body.Close()
+		}
+
+		_ = pc.conn.Close()
+	}
+
+	hs, err := h.credentials.handshakeWith(entry, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	defer releaseHandshake(hs)
+
+	conn, err := h.dial(r.Context(), upstream, r.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	pc := &pooledConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	res, err := exchange(conn, pc.reader, r, hs)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	reusable := !res.Close && res.StatusCode != http.StatusProxyAuthRequired
+	res.Body = &pooledBody{ReadCloser: res.Body, pool: h.conns, key: key, conn: pc, reusable: reusable}
+	return res, nil
+}
+
+func hasBody(r *http.Request) bool {
+	return r.Body != nil && r.Body != http.NoBody
 }
 
 func clearProxyHeaders(r *http.Request) {
@@ -44,11 +163,30 @@ func copyResponse(log *slog.Logger, w http.ResponseWriter, r *http.Response) err
 	w.WriteHeader(r.StatusCode)
 
 	n, err := io.Copy(w, r.Body)
+	bytesIn.Add(float64(n))
 	log.Debug("copied body", slog.Int64("bytes", n))
 
 	return err
 }
 
+// countingReader adds the bytes read to the counter and n, if not nil.
+type countingReader struct {
+	io.Reader
+	counter prometheus.Counter
+	n       *atomic.Int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.counter.Add(float64(n))
+
+	if r.n != nil {
+		r.n.Add(int64(n))
+	}
+
+	return n, err
+}
+
 func copyHeader(dst, src http.Header) {
 	for key, values := range src {
 		dst[key] = slices.Clone(values)
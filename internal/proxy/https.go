@@ -1,13 +1,21 @@
 package proxy
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lukasdietrich/proxyproxy/internal/metrics"
 )
 
 func (h *Handler) proxyHttps(log *slog.Logger, w http.ResponseWriter, r *http.Request) error {
@@ -16,82 +24,236 @@ func (h *Handler) proxyHttps(log *slog.Logger, w http.ResponseWriter, r *http.Re
 		return fmt.Errorf("could not hijack response writer")
 	}
 
-	log.Debug("hijacking response writer")
-	client, _, err := hijacker.Hijack()
+	candidates, err := h.resolve(r)
 	if err != nil {
 		return err
 	}
 
-	//nolint:errcheck
-	defer client.Close()
+	return h.failover(log, r, candidates, func(log *slog.Logger, upstream *url.URL) error {
+		switch {
+		case isSocks(upstream):
+			log.Debug("establishing tunnel through a socks proxy")
+
+		case upstream != nil:
+			log.Debug("establishing tunnel through another proxy")
+
+		default:
+			log.Debug("establishing tunnel to target directly")
+		}
+
+		target, err := h.tunnel(log, upstream, r)
+		if err != nil {
+			var refused *tunnelError
+			if errors.As(err, &refused) {
+				log.Warn("upstream proxy refused the tunnel", slog.Int("status", refused.status))
+			}
+
+			return err
+		}
+
+		//nolint:errcheck
+		defer target.Close()
+
+		tun, err := h.tunnels.open(r, upstream)
+		if err != nil {
+			return err
+		}
+
+		defer h.tunnels.close(tun)
+
+		tun.attach(target)
+
+		log.Debug("hijacking response writer")
+		client, _, err := hijacker.Hijack()
+		if err != nil {
+			return err
+		}
+
+		//nolint:errcheck
+		defer client.Close()
+
+		tun.attach(client)
 
-	return h.establishTunnel(log, client, r)
+		return establishTunnel(log, tun, client, target)
+	})
 }
 
-func (h *Handler) establishTunnel(log *slog.Logger, client net.Conn, r *http.Request) error {
-	t0 := time.Now()
+// tunnel connects to the target of the CONNECT request directly or through a socks proxy. Http
+// upstream proxies are asked to open the tunnel instead. If they refuse, a tunnelError is returned.
+// Rejected credentials are read again and the tunnel is requested once more.
+func (h *Handler) tunnel(log *slog.Logger, upstream *url.URL, r *http.Request) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := h.dial(r.Context(), upstream, r.URL.Host)
+		if err != nil || upstream == nil || isSocks(upstream) {
+			return conn, err
+		}
+
+		target, err := h.requestTunnel(conn, upstream, r)
+		if err == nil {
+			return target, nil
+		}
+
+		_ = conn.Close()
 
-	upstream, err := h.rt.Proxy(r)
+		// Connection errors are already marked as dialError. Nothing was sent on behalf of the client
+		// yet, so it is also safe to try the next candidate, if the target is unavailable. That is not
+		// a failure of the upstream proxy, so it is tried again by the next request.
+		var refused *tunnelError
+		switch {
+		case !errors.As(err, &refused):
+			return nil, err
+
+		case refused.status == http.StatusServiceUnavailable:
+			return nil, &dialError{err}
+
+		case refused.status != http.StatusProxyAuthRequired, attempt > 0:
+			return nil, err
+		}
+
+		changed, err := h.credentials.reload(upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		if !changed {
+			return nil, refused
+		}
+
+		log.Info("upstream proxy rejected the credentials, retrying with reloaded credentials")
+	}
+}
+
+// requestTunnel sends the CONNECT request to the upstream proxy and reads its response. Any status
+// but 200 is returned as tunnelError. Errors of the connection are returned as dialError, while
+// errors of the credentials and the handshake are returned as they are.
+func (h *Handler) requestTunnel(conn net.Conn, upstream *url.URL, r *http.Request) (net.Conn, error) {
+	req := r.Clone(r.Context())
+	clearProxyHeaders(req)
+
+	hs, err := h.credentials.handshake(upstream)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	host := r.URL.Host
-	if upstream != nil {
-		log = log.With(slog.Any("upstream", upstream))
-		log.Debug("establishing tunnel through another proxy")
+	defer releaseHandshake(hs)
 
-		host = upstream.Host
-	} else {
-		log.Debug("establishing tunnel to target directly")
+	if h.dialer.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(h.dialer.Timeout)); err != nil {
+			return nil, &dialError{err}
+		}
 	}
 
-	target, err := net.Dial("tcp", host)
+	reader := bufio.NewReader(conn)
+
+	res, err := exchange(conn, reader, req, hs)
 	if err != nil {
-		return err
+		if !isHandshakeError(err) {
+			err = &dialError{err}
+		}
+
+		return nil, err
 	}
 
-	//nolint:errcheck
-	defer target.Close()
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, &tunnelError{status: res.StatusCode}
+	}
 
-	if upstream != nil {
-		log.Debug("forwarding original request")
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
 
-		// forward request to the upstream proxy
-		if err := r.Write(target); err != nil {
-			return err
-		}
-	} else {
-		// only send a response if we connect to the target directly, otherwise the upstream proxy
-		// sends the respose
-		if _, err := fmt.Fprint(client, "HTTP/1.0 200 Connection established\r\n\r\n"); err != nil {
-			return err
-		}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+func establishTunnel(log *slog.Logger, tun *tunnel, client, target net.Conn) error {
+	t0 := time.Now()
+
+	tun.record.status = http.StatusOK
+
+	if _, err := fmt.Fprint(client, "HTTP/1.0 200 Connection established\r\n\r\n"); err != nil {
+		return err
 	}
 
 	var wg sync.WaitGroup
-	copyAndClose(log, &wg, target, client)
-	copyAndClose(log, &wg, client, target)
+	copyAndClose(log, &wg, target, client, bytesOut, &tun.record.bytesOut)
+	copyAndClose(log, &wg, client, target, bytesIn, &tun.record.bytesIn)
 	wg.Wait()
 
+	metrics.TunnelDuration.Observe(time.Since(t0).Seconds())
 	log.Debug("tunnel closed", slog.Duration("duration", time.Since(t0)))
 	return nil
 }
 
-func copyAndClose(log *slog.Logger, wg *sync.WaitGroup, dst, src net.Conn) {
+// tunnelError is returned, if the upstream proxy refused to open a tunnel.
+type tunnelError struct {
+	status int
+}
+
+func (e *tunnelError) Error() string {
+	return fmt.Sprintf("upstream proxy refused the tunnel: %d %s", e.status, http.StatusText(e.status))
+}
+
+// statusCode maps the status of the upstream proxy to the status for the client. Authentication
+// with the upstream proxy is not the business of the client, so it is reported as a bad gateway.
+func (e *tunnelError) statusCode() int {
+	switch e.status {
+	case http.StatusForbidden, http.StatusGatewayTimeout:
+		return e.status
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// bufferedConn delivers data, that was read ahead while parsing the response of the upstream proxy.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *bufferedConn) CloseRead() error {
+	if conn, ok := c.Conn.(interface{ CloseRead() error }); ok {
+		return conn.CloseRead()
+	}
+
+	return nil
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+
+	return nil
+}
+
+func copyAndClose(log *slog.Logger, wg *sync.WaitGroup, dst, src net.Conn, counter prometheus.Counter, n *atomic.Int64) {
 	wg.Add(1)
 
 	go func() {
-		if _, err := io.Copy(dst, src); err != nil {
+		if _, err := io.Copy(dst, &countingReader{Reader: src, counter: counter, n: n}); err != nil {
 			log.Warn("error while tunneling data", slog.Any("err", err))
 		}
 
-		if err := src.(interface{ CloseRead() error }).CloseRead(); err != nil {
-			log.Debug("could not close source reader", slog.Any("err", err))
+		// tls connections cannot be closed for reading only
+		if src, ok := src.(interface{ CloseRead() error }); ok {
+			if err := src.CloseRead(); err != nil {
+				log.Debug("could not close source reader", slog.Any("err", err))
+			}
 		}
 
-		if err := dst.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
-			log.Debug("could not close target writer", slog.Any("err", err))
+		if dst, ok := dst.(interface{ CloseWrite() error }); ok {
+			if err := dst.CloseWrite(); err != nil {
+				log.Debug("could not close target writer", slog.Any("err", err))
+			}
 		}
 
 		wg.Done()
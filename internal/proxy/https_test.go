@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+// slowSource simulates an expensive pac file, that routes everything directly.
+const slowSource = `
+	function FindProxyForURL(url, host) {
+		var hash = 0;
+		for (var i = 0; i < 1000; i++) {
+			hash = (hash * 31 + host.charCodeAt(i % host.length)) % 65536;
+		}
+
+		return hash < 0 ? "PROXY never:8080" : "DIRECT";
+	}
+`
+
+// serveEcho starts a tcp server, that echoes everything back.
+func serveEcho(tb testing.TB) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(tb, err)
+	tb.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				//nolint:errcheck
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func connect(proxyAddr, target string) error {
+	conn, err := openTunnel(proxyAddr, target)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// openTunnel connects to the target through the proxy and checks the tunnel by sending a ping.
+func openTunnel(proxyAddr, target string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := pingTunnel(conn, target); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func pingTunnel(conn net.Conn, target string) error {
+	if _, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target); err != nil {
+		return err
+	}
+
+	// the echo server sends nothing before the ping, so only the response is buffered
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+
+	if _, err := io.WriteString(conn, "ping"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+
+	if string(buf) != "ping" {
+		return fmt.Errorf("unexpected echo %q", buf)
+	}
+
+	return nil
+}
+
+// refusingUpstream is a proxy, that refuses all requests with the status.
+func refusingUpstream(t testing.TB, status int) string {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(upstream.Close)
+
+	return upstream.Listener.Addr().String()
+}
+
+func TestConnectRefused(t *testing.T) {
+	for upstreamStatus, status := range map[int]int{
+		http.StatusForbidden:               http.StatusForbidden,
+		http.StatusProxyAuthRequired:       http.StatusBadGateway,
+		http.StatusInternalServerError:     http.StatusBadGateway,
+		http.StatusServiceUnavailable:      http.StatusBadGateway,
+		http.StatusGatewayTimeout:          http.StatusGatewayTimeout,
+		http.StatusMethodNotAllowed:        http.StatusBadGateway,
+		http.StatusHTTPVersionNotSupported: http.StatusBadGateway,
+	} {
+		t.Run(strconv.Itoa(upstreamStatus), func(t *testing.T) {
+			proxy := newProxy(t, pacSource("PROXY "+refusingUpstream(t, upstreamStatus)))
+
+			err := connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String())
+			assert.EqualError(t, err, "unexpected status "+strconv.Itoa(status)+" "+http.StatusText(status))
+		})
+	}
+}
+
+func TestConnectUnavailableFailover(t *testing.T) {
+	upstream := httptest.NewServer(fakeUpstream())
+	defer upstream.Close()
+
+	unavailable := &url.URL{Scheme: "proxy", Host: refusingUpstream(t, http.StatusServiceUnavailable)}
+
+	source, err := pac.FromSource([]byte(pacSource("PROXY " + unavailable.Host + "; PROXY " + upstream.Listener.Addr().String())))
+	assert.NoError(t, err)
+
+	handler, err := New(source)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+
+	// the upstream proxy itself is reachable and keeps its position
+	assert.Equal(t, []*url.URL{unavailable, nil}, handler.backoff.order([]*url.URL{unavailable, nil}))
+}
+
+func TestConnectOverrideScheme(t *testing.T) {
+	viper.Set("pac.overrides", `[{"schemes": "https", "target": "BLOCK"}]`)
+	defer viper.Set("pac.overrides", "")
+
+	upstream, err := pac.FromSource([]byte(pacSource("DIRECT")))
+	assert.NoError(t, err)
+	assert.NoError(t, upstream.ReloadOverrides())
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	assert.EqualError(t,
+		connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()),
+		"unexpected status 403 Forbidden")
+}
+
+func TestConnectDirect(t *testing.T) {
+	proxy := newProxy(t, slowSource)
+	assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+}
+
+func BenchmarkConnectParallel(b *testing.B) {
+	echo := serveEcho(b)
+
+	// every request needs to evaluate the pac
+	viper.Set("cache.duration.item", 0)
+	defer viper.Set("cache.duration.item", "30m")
+
+	defer viper.Set("pac.pool.size", viper.GetInt("pac.pool.size"))
+
+	for _, size := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("pool=%d", size), func(b *testing.B) {
+			viper.Set("pac.pool.size", size)
+
+			proxy := newProxy(b, slowSource)
+
+			b.SetParallelism(16)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := connect(proxy.Listener.Addr().String(), echo.Addr().String()); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
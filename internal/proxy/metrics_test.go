@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+func scrape(t testing.TB, url string) (int, string) {
+	res, err := http.Get(url + "/metrics")
+	assert.NoError(t, err)
+
+	//nolint:errcheck
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	return res.StatusCode, string(body)
+}
+
+// newAdmin returns the proxy and admin listeners of a handler.
+func newAdmin(t testing.TB, source string) (*httptest.Server, *httptest.Server) {
+	upstream, err := pac.FromSource([]byte(source))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	t.Cleanup(proxy.Close)
+
+	admin := httptest.NewServer(handler.Admin())
+	t.Cleanup(admin.Close)
+
+	return proxy, admin
+}
+
+func TestMetrics(t *testing.T) {
+	upstream := httptest.NewServer(fakeUpstream())
+	defer upstream.Close()
+
+	closed := closedAddr(t)
+	proxy, admin := newAdmin(t, pacSource("PROXY "+closed+"; PROXY "+upstream.Listener.Addr().String()))
+
+	// the second request hits the cache
+	hello := serveHello(t)
+	for range 2 {
+		res := get(t, proxy, hello.URL)
+		_, _ = io.Copy(io.Discard, res.Body)
+		_ = res.Body.Close()
+	}
+
+	assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+
+	// tunnels are counted after they are closed
+	assert.Eventually(t, func() bool {
+		_, body := scrape(t, admin.URL)
+		return strings.Contains(body, `proxyproxy_requests_total{decision="upstream",method="CONNECT"}`)
+	}, time.Second, 10*time.Millisecond)
+
+	status, body := scrape(t, admin.URL)
+	assert.Equal(t, http.StatusOK, status)
+
+	for _, metric := range []string{
+		`proxyproxy_requests_total{decision="upstream",method="GET"}`,
+		`proxyproxy_tunnel_duration_seconds_count`,
+		`proxyproxy_transferred_bytes_total{direction="in"}`,
+		`proxyproxy_transferred_bytes_total{direction="out"}`,
+		`proxyproxy_pac_evaluation_duration_seconds_count`,
+		`proxyproxy_cache_requests_total{result="hit"}`,
+		`proxyproxy_cache_requests_total{result="miss"}`,
+		`proxyproxy_upstream_errors_total{upstream="` + closed + `"}`,
+	} {
+		assert.Contains(t, body, metric)
+	}
+
+	t.Run("failed", func(t *testing.T) {
+		proxy, admin := newAdmin(t, pacSource("PROXY "+closedAddr(t)))
+
+		proxyUrl, err := url.Parse(proxy.URL)
+		assert.NoError(t, err)
+
+		client := http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyUrl)}}
+
+		req, err := http.NewRequest(http.MethodPatch, hello.URL, nil)
+		assert.NoError(t, err)
+
+		res, err := client.Do(req)
+		assert.NoError(t, err)
+		_ = res.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+
+		// requests are counted, even if every candidate failed
+		_, body := scrape(t, admin.URL)
+		assert.Contains(t, body, `proxyproxy_requests_total{decision="upstream",method="PATCH"} 1`)
+	})
+
+	t.Run("proxy listener", func(t *testing.T) {
+		status, _ := scrape(t, proxy.URL)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		viper.Set("metrics.enabled", false)
+		defer viper.Set("metrics.enabled", true)
+
+		_, admin := newAdmin(t, pacSource("DIRECT"))
+
+		status, _ := scrape(t, admin.URL)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
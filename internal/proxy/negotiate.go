@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	krb5config "github.com/jcmturner/gokrb5/v8/config"
+	krb5credentials "github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("upstream.kerberos.config", config.String, "/etc/krb5.conf")
+}
+
+// negotiateHandshake implements spnego using a kerberos service ticket for HTTP/<proxyhost>.
+type negotiateHandshake struct {
+	session *kerberosSession
+	spn     string
+}
+
+// next sends the token only in response to a Negotiate challenge, so the first request is sent
+// without authorization. Replies of the upstream proxy for mutual authentication are not checked.
+func (h *negotiateHandshake) next(challenges []string) (string, bool, error) {
+	if challenges == nil {
+		return "", false, nil
+	}
+
+	if !hasChallenge(challenges, "Negotiate") {
+		return "", false, fmt.Errorf("upstream proxy does not offer Negotiate authentication")
+	}
+
+	negotiate := spnego.SPNEGOClient(h.session.client, h.spn)
+	if err := negotiate.AcquireCred(); err != nil {
+		return "", false, fmt.Errorf("could not acquire kerberos credentials: %w", err)
+	}
+
+	token, err := negotiate.InitSecContext()
+	if err != nil {
+		return "", false, fmt.Errorf("could not obtain service ticket for %s: %w", h.spn, err)
+	}
+
+	encoded, err := token.Marshal()
+	if err != nil {
+		return "", false, err
+	}
+
+	return "Negotiate " + base64.StdEncoding.EncodeToString(encoded), true, nil
+}
+
+func (h *negotiateHandshake) release() {
+	h.session.release()
+}
+
+// kerberosClient logs in using the keytab of the credential or the credential cache of the user.
+func kerberosClient(entry *credential, configPath string) (*client.Client, error) {
+	config, err := krb5config.Load(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load kerberos config: %w", err)
+	}
+
+	settings := client.DisablePAFXFAST(true)
+
+	if entry.Keytab != "" {
+		kt, err := keytab.Load(entry.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("could not load keytab: %w", err)
+		}
+
+		username, realm, ok := strings.Cut(entry.Username, "@")
+		if !ok {
+			realm = config.LibDefaults.DefaultRealm
+		}
+
+		return client.NewWithKeytab(username, realm, kt, config, settings), nil
+	}
+
+	ccache, err := krb5credentials.LoadCCache(ccachePath(entry))
+	if err != nil {
+		return nil, fmt.Errorf("could not load credential cache: %w", err)
+	}
+
+	return client.NewFromCCache(ccache, config, settings)
+}
+
+// ccachePath returns the configured credential cache, falling back to KRB5CCNAME and the default
+// location of MIT kerberos. Only file based caches are supported.
+func ccachePath(entry *credential) string {
+	if entry.CCache != "" {
+		return entry.CCache
+	}
+
+	if name, ok := os.LookupEnv("KRB5CCNAME"); ok {
+		return strings.TrimPrefix(name, "FILE:")
+	}
+
+	return fmt.Sprintf("/tmp/krb5cc_%d", os.Getuid())
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testRealm = "TEST.EXAMPLE"
+	testEType = etypeID.AES256_CTS_HMAC_SHA1_96
+)
+
+// newKeytab returns a keytab with an entry for each principal, using the principal as password.
+func newKeytab(t testing.TB, principals ...string) *keytab.Keytab {
+	kt := keytab.New()
+	for _, principal := range principals {
+		assert.NoError(t, kt.AddEntry(principal, testRealm, principal, time.Now(), 1, testEType))
+	}
+
+	return kt
+}
+
+// serveKDC is a minimal kdc stand-in, that issues tickets for every principal of the keytab without
+// checking pre-authentication.
+func serveKDC(t testing.TB, kt *keytab.Keytab) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				//nolint:errcheck
+				defer conn.Close()
+
+				var length uint32
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+
+				req := make([]byte, length)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+
+				res, err := kdcExchange(kt, req)
+				if err != nil {
+					t.Errorf("kdc: %v", err)
+					return
+				}
+
+				_ = binary.Write(conn, binary.BigEndian, uint32(len(res)))
+				_, _ = conn.Write(res)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+func kdcExchange(kt *keytab.Keytab, req []byte) ([]byte, error) {
+	var (
+		asReq  messages.ASReq
+		tgsReq messages.TGSReq
+	)
+
+	if err := asReq.Unmarshal(req); err == nil {
+		// the reply is encrypted using the key of the client
+		key, _, err := kt.GetEncryptionKey(asReq.ReqBody.CName, testRealm, 1, testEType)
+		if err != nil {
+			return nil, err
+		}
+
+		rep, err := kdcReply(kt, asReq.ReqBody, asReq.ReqBody.CName, key, keyusage.AS_REP_ENCPART)
+		if err != nil {
+			return nil, err
+		}
+
+		rep.MsgType = msgtype.KRB_AS_REP
+		return (&messages.ASRep{KDCRepFields: rep}).Marshal()
+	}
+
+	if err := tgsReq.Unmarshal(req); err != nil {
+		return nil, err
+	}
+
+	// the reply is encrypted using the session key of the ticket granting ticket
+	for _, pa := range tgsReq.PAData {
+		if pa.PADataType != patype.PA_TGS_REQ {
+			continue
+		}
+
+		var apReq messages.APReq
+		if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+			return nil, err
+		}
+
+		if err := apReq.Ticket.DecryptEncPart(kt, &apReq.Ticket.SName); err != nil {
+			return nil, err
+		}
+
+		rep, err := kdcReply(kt, tgsReq.ReqBody, apReq.Ticket.DecryptedEncPart.CName,
+			apReq.Ticket.DecryptedEncPart.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY)
+		if err != nil {
+			return nil, err
+		}
+
+		rep.MsgType = msgtype.KRB_TGS_REP
+		return (&messages.TGSRep{KDCRepFields: rep}).Marshal()
+	}
+
+	return nil, fmt.Errorf("tgs request without ticket granting ticket")
+}
+
+func kdcReply(kt *keytab.Keytab, body messages.KDCReqBody, cname types.PrincipalName, key types.EncryptionKey, usage uint32) (messages.KDCRepFields, error) {
+	var (
+		now   = time.Now().UTC().Truncate(time.Second)
+		end   = now.Add(time.Hour)
+		flags = asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+	)
+
+	ticket, sessionKey, err := messages.NewTicket(cname, testRealm, body.SName, testRealm, flags, kt, testEType, 1, now, now, end, end)
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+
+	encPart := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{{LRValue: now}},
+		Nonce:     body.Nonce,
+		Flags:     flags,
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   end,
+		RenewTill: end,
+		SRealm:    testRealm,
+		SName:     body.SName,
+	}
+
+	plain, err := encPart.Marshal()
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+
+	encrypted, err := crypto.GetEncryptedData(plain, key, usage, 1)
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+
+	return messages.KDCRepFields{
+		PVNO:    5,
+		CRealm:  testRealm,
+		CName:   body.CName,
+		Ticket:  ticket,
+		EncPart: encrypted,
+	}, nil
+}
+
+// fakeNegotiateUpstream is a proxy, that requires a spnego token for its service principal. The
+// first valid token is rejected anyway, to simulate an expired ticket.
+func fakeNegotiateUpstream(t testing.TB, next http.Handler, kt *keytab.Keytab) (*httptest.Server, *atomic.Int32) {
+	var (
+		accepted   atomic.Int32
+		challenged atomic.Bool
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Proxy-Authorization"), "Negotiate ")
+		if ok && !challenged.Load() {
+			t.Errorf("token sent before the challenge")
+		}
+
+		if ok {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			assert.NoError(t, err)
+
+			var st spnego.SPNEGOToken
+			assert.NoError(t, st.Unmarshal(decoded))
+
+			if valid, _, status := spnego.SPNEGOService(kt).AcceptSecContext(&st); !valid {
+				t.Errorf("invalid spnego token: %s", status.Message)
+			} else if accepted.Add(1) > 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		challenged.Store(true)
+		w.Header().Set("Proxy-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &accepted
+}
+
+func TestNegotiate(t *testing.T) {
+	var (
+		service = newKeytab(t, "HTTP/127.0.0.1")
+		kdc     = serveKDC(t, newKeytab(t, "krbtgt/"+testRealm, "alice", "HTTP/127.0.0.1"))
+		dir     = t.TempDir()
+	)
+
+	clientKeytab, err := newKeytab(t, "alice").Marshal()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "alice.keytab"), clientKeytab, 0o600))
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "krb5.conf"), fmt.Appendf(nil, `
+[libdefaults]
+  default_realm = %[1]s
+  udp_preference_limit = 1
+  dns_lookup_kdc = false
+
+[realms]
+  %[1]s = {
+    kdc = %[2]s
+  }
+`, testRealm, kdc.Addr()), 0o600))
+
+	viper.Set("upstream.kerberos.config", filepath.Join(dir, "krb5.conf"))
+	defer viper.Set("upstream.kerberos.config", "/etc/krb5.conf")
+
+	viper.Set("upstream.auth", `[
+		{"match": "127.0.0.1", "scheme": "negotiate", "username": "alice", "keytab": "`+filepath.Join(dir, "alice.keytab")+`"}
+	]`)
+	defer viper.Set("upstream.auth", "")
+
+	t.Run("forward", func(t *testing.T) {
+		upstream, accepted := fakeNegotiateUpstream(t, fakeUpstream(), service)
+		proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+		res := get(t, proxy, serveHello(t).URL)
+
+		//nolint:errcheck
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+		assert.EqualValues(t, 2, accepted.Load())
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		upstream, accepted := fakeNegotiateUpstream(t, fakeUpstream(), service)
+		proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+		assert.NoError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()))
+		assert.EqualValues(t, 2, accepted.Load())
+	})
+
+	t.Run("not offered", func(t *testing.T) {
+		upstream := httptest.NewServer(requireBasicAuth(fakeUpstream(), "alice", "secret"))
+		defer upstream.Close()
+
+		proxy := newProxy(t, pacSource("PROXY "+upstream.Listener.Addr().String()))
+
+		res := get(t, proxy, serveHello(t).URL)
+		_ = res.Body.Close()
+
+		assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+		assert.EqualError(t, connect(proxy.Listener.Addr().String(), serveEcho(t).Addr().String()),
+			"unexpected status 502 Bad Gateway")
+	})
+}
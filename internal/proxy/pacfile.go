@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+func init() {
+	config.Define("http.pac.mode", config.String, pacModeGenerate)
+	config.Define("http.pac.proxy", config.String, "")
+	config.Define("http.pac.noproxy", config.Structure, "localhost,127.0.0.1")
+	config.AddValidator(func() error {
+		_, err := pacFileFromEnv(nil)
+		return err
+	})
+}
+
+const (
+	pacModeGenerate = "generate"
+	pacModeRewrite  = "rewrite"
+	pacModeOff      = "off"
+)
+
+// pacFile serves a pac for the clients of proxyproxy, pointing them at proxyproxy.
+type pacFile struct {
+	upstream *pac.Config
+	rewrite  bool
+	// proxy is the address of proxyproxy. If empty, the address the client connected to is used.
+	proxy   string
+	noProxy []string
+}
+
+// pacFileFromEnv returns nil, if serving a pac is disabled.
+func pacFileFromEnv(upstream *pac.Config) (*pacFile, error) {
+	mode := strings.ToLower(viper.GetString("http.pac.mode"))
+
+	switch mode {
+	case pacModeOff:
+		return nil, nil
+
+	case pacModeGenerate, pacModeRewrite:
+
+	default:
+		return nil, fmt.Errorf("invalid http.pac.mode: must be %s, %s or %s",
+			pacModeGenerate, pacModeRewrite, pacModeOff)
+	}
+
+	var noProxy []string
+	if err := config.UnmarshalKey("http.pac.noproxy", &noProxy); err != nil {
+		return nil, fmt.Errorf("invalid http.pac.noproxy: %w", err)
+	}
+
+	return &pacFile{
+		upstream: upstream,
+		rewrite:  mode == pacModeRewrite,
+		proxy:    viper.GetString("http.pac.proxy"),
+		noProxy:  noProxy,
+	}, nil
+}
+
+func (p *pacFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	proxy := p.proxy
+	if proxy == "" {
+		proxy = localAddr(r)
+	}
+
+	var source []byte
+	if upstream := p.upstream.Source(); p.rewrite && upstream != nil {
+		source = pac.Rewrite(upstream, proxy, p.noProxy)
+	} else {
+		source = pac.Generate(proxy, p.noProxy)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Cache-Control", "no-cache")
+	_, _ = w.Write(source)
+}
+
+// localAddr returns the address the client used to reach proxyproxy. The port is taken from the
+// connection, if the host header does not contain one.
+func localAddr(r *http.Request) string {
+	if _, _, err := net.SplitHostPort(r.Host); err == nil {
+		return r.Host
+	}
+
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return r.Host
+	}
+
+	host, port, err := net.SplitHostPort(local.String())
+	if err != nil {
+		return r.Host
+	}
+
+	if r.Host != "" {
+		host = strings.Trim(r.Host, "[]")
+	}
+
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func fetchPac(t testing.TB, url string) (int, string) {
+	res, err := http.Get(url)
+	assert.NoError(t, err)
+
+	//nolint:errcheck
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	return res.StatusCode, string(body)
+}
+
+func TestPacFile(t *testing.T) {
+	t.Run("generate", func(t *testing.T) {
+		proxy := newProxy(t, pacSource("PROXY corporate:8080"))
+
+		for _, path := range []string{"/proxy.pac", "/wpad.dat"} {
+			status, body := fetchPac(t, proxy.URL+path)
+			assert.Equal(t, http.StatusOK, status)
+			assert.Contains(t, body, `"PROXY `+proxy.Listener.Addr().String()+`"`)
+			assert.Contains(t, body, `dnsDomainIs(host, ".localhost")`)
+			assert.NotContains(t, body, "corporate")
+		}
+
+		status, _ := fetchPac(t, proxy.URL+"/other")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		viper.Set("http.pac.mode", pacModeRewrite)
+		viper.Set("http.pac.proxy", "proxyproxy.example.org:8080")
+		defer viper.Set("http.pac.mode", pacModeGenerate)
+		defer viper.Set("http.pac.proxy", "")
+
+		proxy := newProxy(t, pacSource("PROXY corporate:8080"))
+
+		status, body := fetchPac(t, proxy.URL+"/proxy.pac")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, `"PROXY proxyproxy.example.org:8080"`)
+		assert.Contains(t, body, "corporate")
+	})
+
+	t.Run("off", func(t *testing.T) {
+		viper.Set("http.pac.mode", pacModeOff)
+		defer viper.Set("http.pac.mode", pacModeGenerate)
+
+		proxy := newProxy(t, pacSource("PROXY corporate:8080"))
+
+		status, _ := fetchPac(t, proxy.URL+"/proxy.pac")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/lukasdietrich/proxyproxy/internal/accesslog"
+)
+
+type recordContextKey struct{}
+
+// record collects what happened to a request for the access log.
+type record struct {
+	id      xid.ID
+	started time.Time
+	user    string
+	// upstream is the last upstream proxy tried, if forwarded is set. Nil stands for a direct
+	// connection.
+	upstream  *url.URL
+	forwarded bool
+	status    int
+	// bytesIn are sent to the client and bytesOut are sent to the target.
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+func newRecord() *record {
+	return &record{
+		id:      xid.New(),
+		started: time.Now(),
+	}
+}
+
+// withRecord stores the record of a request in its context.
+func withRecord(r *http.Request, rec *record) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), recordContextKey{}, rec))
+}
+
+// recordFromRequest returns the record stored by withRecord or a new one.
+func recordFromRequest(r *http.Request) *record {
+	if rec, ok := r.Context().Value(recordContextKey{}).(*record); ok {
+		return rec
+	}
+
+	return newRecord()
+}
+
+func (rec *record) entry(r *http.Request) *accesslog.Entry {
+	entry := accesslog.Entry{
+		Time:      rec.started,
+		ID:        rec.id.String(),
+		Client:    r.RemoteAddr,
+		User:      rec.user,
+		Method:    r.Method,
+		Target:    r.RequestURI,
+		Proto:     r.Proto,
+		Status:    rec.status,
+		BytesIn:   rec.bytesIn.Load(),
+		BytesOut:  rec.bytesOut.Load(),
+		Duration:  time.Since(rec.started),
+		Referer:   r.Referer(),
+		UserAgent: r.UserAgent(),
+	}
+
+	if rec.forwarded {
+		entry.Upstream = formatUpstream(rec.upstream)
+	}
+
+	// the http server responds with 200, if nothing was written
+	if entry.Status == 0 {
+		entry.Status = http.StatusOK
+	}
+
+	return &entry
+}
+
+// recordingWriter records the status and the bytes sent to the client.
+type recordingWriter struct {
+	http.ResponseWriter
+	rec *record
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.rec.status == 0 {
+		w.rec.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.rec.status == 0 {
+		w.rec.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.rec.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return hijacker.Hijack()
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"sync/atomic"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+// Reloaders apply changed settings to the running handler. Requests and tunnels, that are already
+// open, keep using the previous settings.
+func (h *Handler) Reloaders() []config.Reloader {
+	return append(h.upstream.Reloaders(),
+		config.Reloader{
+			Keys: []string{"cache.duration.item", "cache.interval.gc"},
+			Apply: func() error {
+				h.cache.Reload()
+				return nil
+			},
+		},
+		config.Reloader{
+			Keys:  []string{"http.auth.htpasswd", "http.auth.token", "http.auth.realm"},
+			Apply: reloadPointer(&h.clientAuth, clientAuthFromEnv),
+		},
+		config.Reloader{
+			Keys:  []string{"http.allow", "http.deny"},
+			Apply: reloadPointer(&h.accessList, accessListFromEnv),
+		},
+		config.Reloader{
+			Keys:  []string{"destination.rules", "destination.default", "destination.timeout.dns"},
+			Apply: reloadPointer(&h.destinationRules, destinationRulesFromEnv),
+		},
+		config.Reloader{
+			Keys: []string{
+				"upstream.auth",
+				"upstream.kerberos.config",
+				"upstream.socks.username",
+				"upstream.socks.password",
+				"upstream.socks.dns.remote",
+			},
+			Apply: h.reloadCredentials,
+		},
+	)
+}
+
+func (h *Handler) reloadCredentials() error {
+	credentials, err := credentialsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	h.credentials.replace(credentials)
+	h.socks.Store(socksSettingsFromEnv())
+
+	// socks transports and pooled connections hold the previous credentials
+	h.flushTransports()
+	h.conns.flush()
+	return nil
+}
+
+// reloadPointer replaces the value of p with a new one from env. A nil value disables the feature.
+func reloadPointer[T any](p *atomic.Pointer[T], fromEnv func() (*T, error)) func() error {
+	return func() error {
+		value, err := fromEnv()
+		if err != nil {
+			return err
+		}
+
+		p.Store(value)
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+func reloadKey(t *testing.T, handler *Handler, key string) {
+	index := slices.IndexFunc(handler.Reloaders(), func(reloader config.Reloader) bool {
+		return slices.Contains(reloader.Keys, key)
+	})
+
+	if assert.NotEqual(t, -1, index, key) {
+		assert.NoError(t, handler.Reloaders()[index].Apply())
+	}
+}
+
+func TestReloaders(t *testing.T) {
+	defer viper.Set("http.auth.token", "")
+	defer viper.Set("destination.default", actionAllow)
+	defer viper.Set("upstream.auth", "")
+	defer viper.Set("upstream.socks.username", "")
+
+	upstream, err := pac.FromSource([]byte(pacSource("DIRECT")))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	target := serveHello(t).URL
+	status := func() int {
+		res := get(t, proxy, target)
+		_ = res.Body.Close()
+		return res.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, status())
+
+	viper.Set("http.auth.token", "t0ken")
+	reloadKey(t, handler, "http.auth.token")
+	assert.Equal(t, http.StatusProxyAuthRequired, status())
+
+	viper.Set("http.auth.token", "")
+	reloadKey(t, handler, "http.auth.token")
+	assert.Equal(t, http.StatusOK, status())
+
+	viper.Set("destination.default", actionDeny)
+	reloadKey(t, handler, "destination.default")
+	assert.Equal(t, http.StatusForbidden, status())
+
+	corporate := &url.URL{Scheme: "http", Host: "corporate:8080"}
+	assert.Nil(t, handler.credentials.lookup(corporate))
+
+	viper.Set("upstream.auth", `[{"match": "corporate:8080", "username": "alice"}]`)
+	reloadKey(t, handler, "upstream.auth")
+	assert.NotNil(t, handler.credentials.lookup(corporate))
+
+	socksUpstream := &url.URL{Scheme: "socks5", Host: "socks:1080"}
+	handler.transport(socksUpstream)
+
+	viper.Set("upstream.socks.username", "bob")
+	reloadKey(t, handler, "upstream.socks.username")
+	assert.Equal(t, "bob", handler.socksDialer(socksUpstream).Username)
+
+	_, cached := handler.transports.Load(socksUpstream.String())
+	assert.False(t, cached)
+
+	for _, key := range []string{"pac.overrides", "cache.duration.item", "http.allow"} {
+		reloadKey(t, handler, key)
+	}
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lukasdietrich/proxyproxy/internal/pac"
+)
+
+// newShutdownProxy returns a handler with its proxy listener.
+func newShutdownProxy(t testing.TB) (*Handler, string) {
+	upstream, err := pac.FromSource([]byte(pacSource("DIRECT")))
+	assert.NoError(t, err)
+
+	handler, err := New(upstream)
+	assert.NoError(t, err)
+
+	proxy := httptest.NewServer(handler)
+	t.Cleanup(proxy.Close)
+
+	return handler, proxy.Listener.Addr().String()
+}
+
+func TestShutdown(t *testing.T) {
+	echo := serveEcho(t).Addr().String()
+
+	t.Run("drained", func(t *testing.T) {
+		handler, proxy := newShutdownProxy(t)
+
+		conn, err := openTunnel(proxy, echo)
+		assert.NoError(t, err)
+
+		time.AfterFunc(50*time.Millisecond, func() { _ = conn.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		assert.NoError(t, handler.Shutdown(ctx))
+
+		// no tunnels are opened while draining
+		assert.EqualError(t, connect(proxy, echo), "unexpected status 503 Service Unavailable")
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		handler, proxy := newShutdownProxy(t)
+
+		conn, err := openTunnel(proxy, echo)
+		assert.NoError(t, err)
+
+		//nolint:errcheck
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, handler.Shutdown(ctx), context.DeadlineExceeded)
+		assert.Empty(t, handler.tunnels.list())
+
+		_, err = conn.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
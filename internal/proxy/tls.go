@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
+)
+
+func init() {
+	config.Define("upstream.tls.ca", config.String, "")
+	config.Define("upstream.tls.servername", config.String, "")
+	config.Define("upstream.tls.cert", config.String, "")
+	config.Define("upstream.tls.key", config.String, "")
+	config.AddValidator(func() error {
+		_, err := upstreamTLSConfigFromEnv()
+		return err
+	})
+}
+
+// upstreamTLSConfigFromEnv creates the tls configuration to connect to https upstream proxies. The
+// ca bundle is used in addition to the system roots.
+func upstreamTLSConfigFromEnv() (*tls.Config, error) {
+	config := tls.Config{
+		ServerName: viper.GetString("upstream.tls.servername"),
+	}
+
+	if path := viper.GetString("upstream.tls.ca"); path != "" {
+		bundle, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream.tls.ca: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("invalid upstream.tls.ca: no certificates found in %s", path)
+		}
+
+		config.RootCAs = pool
+	}
+
+	certFile := viper.GetString("upstream.tls.cert")
+	keyFile := viper.GetString("upstream.tls.key")
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream.tls.cert or upstream.tls.key: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &config, nil
+}
+
+func (h *Handler) dialTLS(ctx context.Context, upstream *url.URL) (net.Conn, error) {
+	conn, err := h.dialer.DialContext(ctx, "tcp", upstream.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	config := h.tlsConfig.Clone()
+	if config.ServerName == "" {
+		config.ServerName = upstream.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		//nolint:errcheck
+		conn.Close()
+
+		return nil, fmt.Errorf("tls handshake with upstream %s failed: %w", upstream.Host, err)
+	}
+
+	return tlsConn, nil
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// errDraining is returned by open, once the tunnels are drained on shutdown.
+	errDraining = errors.New("shutting down, not opening new tunnels")
+)
+
+// tunnel is an established CONNECT tunnel.
+type tunnel struct {
+	// record of the CONNECT request, which counts the transferred bytes.
+	record   *record
+	client   string
+	target   string
+	upstream *url.URL
+
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed bool
+}
+
+// attach registers a connection of the tunnel, so it can be closed forcibly.
+func (t *tunnel) attach(conn net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		_ = conn.Close()
+		return
+	}
+
+	t.conns = append(t.conns, conn)
+}
+
+// forceClose closes the connections of the tunnel, which ends copying the data.
+func (t *tunnel) forceClose() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+
+	for _, conn := range t.conns {
+		_ = conn.Close()
+	}
+}
+
+// tunnels keeps track of the established tunnels. Hijacked connections are not seen by the http
+// server anymore, so they have to be drained separately on shutdown.
+type tunnels struct {
+	active sync.Map
+	wg     sync.WaitGroup
+
+	// mu guards draining, so that no tunnel is added to wg while drain waits for it.
+	mu       sync.Mutex
+	draining bool
+}
+
+// open registers a tunnel. It is called before the connection of the client is hijacked, so the
+// tunnel is known once the http server considers the connection gone. Once the tunnels are
+// drained, errDraining is returned.
+func (t *tunnels) open(r *http.Request, upstream *url.URL) (*tunnel, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return nil, errDraining
+	}
+
+	tun := tunnel{
+		record:   recordFromRequest(r),
+		client:   r.RemoteAddr,
+		target:   r.URL.Host,
+		upstream: upstream,
+	}
+
+	t.wg.Add(1)
+	t.active.Store(&tun, struct{}{})
+	return &tun, nil
+}
+
+func (t *tunnels) close(tun *tunnel) {
+	t.active.Delete(tun)
+	t.wg.Done()
+}
+
+// drain waits for all tunnels to be closed and refuses to open new ones. When the context is done,
+// the remaining tunnels are closed forcibly.
+func (t *tunnels) drain(ctx context.Context) error {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+
+	case <-ctx.Done():
+	}
+
+	for _, tun := range t.list() {
+		slog.Warn("closing tunnel forcibly",
+			slog.Any("id", tun.record.id),
+			slog.String("target", tun.target),
+			slog.Duration("age", time.Since(tun.record.started)),
+		)
+
+		tun.forceClose()
+	}
+
+	<-done
+	return ctx.Err()
+}
+
+// list returns the established tunnels, oldest first.
+func (t *tunnels) list() []*tunnel {
+	var list []*tunnel
+
+	t.active.Range(func(key, _ any) bool {
+		list = append(list, key.(*tunnel))
+		return true
+	})
+
+	slices.SortFunc(list, func(a, b *tunnel) int {
+		return a.record.started.Compare(b.record.started)
+	})
+
+	return list
+}
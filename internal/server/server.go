@@ -4,15 +4,21 @@ import (
 	"net/http"
 
 	"github.com/spf13/viper"
+
+	"github.com/lukasdietrich/proxyproxy/internal/config"
 )
 
 func init() {
-	viper.SetDefault("http.addr", ":8080")
-	viper.SetDefault("http.timeout.read", "30s")
-	viper.SetDefault("http.timeout.read.header", "10s")
-	viper.SetDefault("http.timeout.write", "600s")
-	viper.SetDefault("http.timeout.idle", "30s")
-	viper.SetDefault("http.limit.header.bytes", "640k")
+	config.Define("http.addr", config.String, ":8080")
+	config.Define("http.timeout.read", config.Duration, "30s")
+	config.Define("http.timeout.header", config.Duration, "10s")
+	// http.timeout.read.header could not be set in a config file together with http.timeout.read
+	config.Rename("http.timeout.read.header", "http.timeout.header")
+	config.Define("http.timeout.write", config.Duration, "600s")
+	config.Define("http.timeout.idle", config.Duration, "30s")
+	config.Define("http.timeout.shutdown", config.Duration, "30s")
+	config.Define("http.limit.header.bytes", config.Size, "640k")
+	config.Define("admin.addr", config.String, "")
 }
 
 func FromEnv(handler http.Handler) *http.Server {
@@ -20,9 +26,23 @@ func FromEnv(handler http.Handler) *http.Server {
 		Addr:              viper.GetString("http.addr"),
 		Handler:           handler,
 		ReadTimeout:       viper.GetDuration("http.timeout.read"),
-		ReadHeaderTimeout: viper.GetDuration("http.timeout.read.header"),
+		ReadHeaderTimeout: viper.GetDuration("http.timeout.header"),
 		WriteTimeout:      viper.GetDuration("http.timeout.write"),
 		IdleTimeout:       viper.GetDuration("http.timeout.idle"),
 		MaxHeaderBytes:    int(viper.GetSizeInBytes("http.limit.header.bytes")),
 	}
 }
+
+// AdminFromEnv returns nil, if the admin api is disabled.
+func AdminFromEnv(handler http.Handler) *http.Server {
+	addr := viper.GetString("admin.addr")
+	if addr == "" {
+		return nil
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: viper.GetDuration("http.timeout.header"),
+	}
+}
@@ -0,0 +1,335 @@
+package socks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+const (
+	Version4 = 4
+	Version5 = 5
+
+	socks4Connect = 1
+	socks4Granted = 90
+
+	socks5Connect      = 1
+	socks5NoAuth       = 0
+	socks5UserPass     = 2
+	socks5NoAcceptable = 0xff
+	socks5AddrIPv4     = 1
+	socks5AddrDomain   = 3
+	socks5AddrIPv6     = 4
+	socks5Succeeded    = 0
+	userPassVersion    = 1
+)
+
+var (
+	socks5Replies = map[byte]string{
+		1: "general failure",
+		2: "connection not allowed by ruleset",
+		3: "network unreachable",
+		4: "host unreachable",
+		5: "connection refused",
+		6: "ttl expired",
+		7: "command not supported",
+		8: "address type not supported",
+	}
+)
+
+// ContextDialer dials the socks server.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Dialer establishes connections through a socks server.
+type Dialer struct {
+	// Version is either Version4 or Version5.
+	Version int
+	// Addr is the address of the socks server.
+	Addr string
+	// Username and Password are used to authenticate with a SOCKS5 server. SOCKS4 only sends the
+	// Username as user id.
+	Username string
+	Password string
+	// RemoteDNS lets the socks server resolve host names (SOCKS4a or SOCKS5 with domain names)
+	// instead of resolving them locally.
+	RemoteDNS bool
+	// Forward is used to connect to the socks server.
+	Forward ContextDialer
+	// Timeout limits the handshake with the socks server, in addition to the deadline of the context.
+	Timeout time.Duration
+}
+
+// DialContext connects to addr through the socks server. The returned connection is the
+// connection to the socks server, so it can be closed for reading or writing independently.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks: unsupported network %s", network)
+	}
+
+	host, port, err := splitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.RemoteDNS {
+		if host, err = d.resolve(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := d.Forward.DialContext(ctx, "tcp", d.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := d.deadline(ctx); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	switch d.Version {
+	case Version4:
+		err = d.connect4(conn, host, port)
+	case Version5:
+		err = d.connect5(conn, host, port)
+	default:
+		err = fmt.Errorf("socks: unsupported version %d", d.Version)
+	}
+
+	if err == nil {
+		err = conn.SetDeadline(time.Time{})
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// deadline returns the earlier of the deadline of the context and the timeout.
+func (d *Dialer) deadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+
+	if d.Timeout > 0 {
+		if timeout := time.Now().Add(d.Timeout); !ok || timeout.Before(deadline) {
+			return timeout, true
+		}
+	}
+
+	return deadline, ok
+}
+
+func (d *Dialer) resolve(ctx context.Context, host string) (string, error) {
+	if _, err := netip.ParseAddr(host); err == nil {
+		return host, nil
+	}
+
+	network := "ip"
+	if d.Version == Version4 {
+		network = "ip4"
+	}
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+	if err != nil {
+		return "", err
+	}
+
+	return ips[0].Unmap().String(), nil
+}
+
+// connect4 implements SOCKS4 and SOCKS4a.
+// See https://www.openssh.com/txt/socks4.protocol and https://www.openssh.com/txt/socks4a.protocol
+func (d *Dialer) connect4(conn net.Conn, host string, port uint16) error {
+	req := []byte{Version4, socks4Connect}
+	req = binary.BigEndian.AppendUint16(req, port)
+
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err == nil && ip.Unmap().Is4():
+		addr := ip.Unmap().As4()
+		req = append(req, addr[:]...)
+		req = append(req, d.Username...)
+		req = append(req, 0)
+
+	case err == nil:
+		return fmt.Errorf("socks4: ipv6 address %s is not supported", host)
+
+	default:
+		// SOCKS4a signals a domain name with the invalid ip 0.0.0.x
+		req = append(req, 0, 0, 0, 1)
+		req = append(req, d.Username...)
+		req = append(req, 0)
+		req = append(req, host...)
+		req = append(req, 0)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	res := make([]byte, 8)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+
+	if res[0] != 0 {
+		return fmt.Errorf("socks4: unexpected reply version %d", res[0])
+	}
+
+	if res[1] != socks4Granted {
+		return fmt.Errorf("socks4: request rejected with code %d", res[1])
+	}
+
+	return nil
+}
+
+// connect5 implements SOCKS5 with optional username/password authentication.
+// See https://datatracker.ietf.org/doc/html/rfc1928 and https://datatracker.ietf.org/doc/html/rfc1929
+func (d *Dialer) connect5(conn net.Conn, host string, port uint16) error {
+	methods := []byte{socks5NoAuth}
+	if d.Username != "" {
+		methods = append(methods, socks5UserPass)
+	}
+
+	greeting := append([]byte{Version5, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	choice := make([]byte, 2)
+	if _, err := io.ReadFull(conn, choice); err != nil {
+		return err
+	}
+
+	if choice[0] != Version5 {
+		return fmt.Errorf("socks5: unexpected version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case socks5NoAuth:
+	case socks5UserPass:
+		if err := d.authenticate5(conn); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return errors.New("socks5: no acceptable authentication method")
+	default:
+		return fmt.Errorf("socks5: unsupported authentication method %d", choice[1])
+	}
+
+	req := []byte{Version5, socks5Connect, 0}
+
+	ip, err := netip.ParseAddr(host)
+	switch {
+	case err == nil && ip.Unmap().Is4():
+		addr := ip.Unmap().As4()
+		req = append(req, socks5AddrIPv4)
+		req = append(req, addr[:]...)
+
+	case err == nil:
+		addr := ip.As16()
+		req = append(req, socks5AddrIPv6)
+		req = append(req, addr[:]...)
+
+	case len(host) > 255:
+		return fmt.Errorf("socks5: host name %q is too long", host)
+
+	default:
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	res := make([]byte, 4)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+
+	if res[1] != socks5Succeeded {
+		reason, ok := socks5Replies[res[1]]
+		if !ok {
+			reason = "code " + strconv.Itoa(int(res[1]))
+		}
+
+		return fmt.Errorf("socks5: request failed: %s", reason)
+	}
+
+	// skip the bound address, which is not needed
+	var skip int
+	switch res[3] {
+	case socks5AddrIPv4:
+		skip = 4
+	case socks5AddrIPv6:
+		skip = 16
+	case socks5AddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+
+		skip = int(length[0])
+	default:
+		return fmt.Errorf("socks5: unexpected address type %d", res[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func (d *Dialer) authenticate5(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("socks5: username or password is too long")
+	}
+
+	req := []byte{userPassVersion, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	res := make([]byte, 2)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return err
+	}
+
+	if res[1] != socks5Succeeded {
+		return errors.New("socks5: authentication failed")
+	}
+
+	return nil
+}
+
+func splitHostPort(addr string) (string, uint16, error) {
+	host, portString, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("socks: invalid port in %q", addr)
+	}
+
+	return host, uint16(port), nil
+}
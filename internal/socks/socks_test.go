@@ -0,0 +1,230 @@
+package socks
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// request is what the fake socks server received.
+type request struct {
+	version  byte
+	host     string
+	port     uint16
+	username string
+	password string
+}
+
+// serveSocks starts a fake socks server, that accepts a single connection, records the request and
+// echoes everything afterwards.
+func serveSocks(t *testing.T) (string, <-chan request) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	requests := make(chan request, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		//nolint:errcheck
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		version, _ := r.ReadByte()
+
+		req := request{version: version}
+
+		switch version {
+		case Version4:
+			header := make([]byte, 7)
+			_, _ = io.ReadFull(r, header)
+
+			req.port = binary.BigEndian.Uint16(header[1:3])
+			req.host = net.IP(header[3:7]).String()
+			req.username, _ = r.ReadString(0)
+			req.username = req.username[:len(req.username)-1]
+
+			if header[3] == 0 && header[4] == 0 && header[5] == 0 {
+				req.host, _ = r.ReadString(0)
+				req.host = req.host[:len(req.host)-1]
+			}
+
+			_, _ = conn.Write([]byte{0, socks4Granted, 0, 0, 0, 0, 0, 0})
+
+		case Version5:
+			count, _ := r.ReadByte()
+			methods := make([]byte, count)
+			_, _ = io.ReadFull(r, methods)
+
+			if count == 2 {
+				_, _ = conn.Write([]byte{Version5, socks5UserPass})
+
+				_, _ = r.ReadByte()
+				length, _ := r.ReadByte()
+				username := make([]byte, length)
+				_, _ = io.ReadFull(r, username)
+				length, _ = r.ReadByte()
+				password := make([]byte, length)
+				_, _ = io.ReadFull(r, password)
+
+				req.username, req.password = string(username), string(password)
+				_, _ = conn.Write([]byte{userPassVersion, socks5Succeeded})
+			} else {
+				_, _ = conn.Write([]byte{Version5, socks5NoAuth})
+			}
+
+			header := make([]byte, 4)
+			_, _ = io.ReadFull(r, header)
+
+			switch header[3] {
+			case socks5AddrIPv4:
+				addr := make([]byte, 4)
+				_, _ = io.ReadFull(r, addr)
+				req.host = net.IP(addr).String()
+			case socks5AddrDomain:
+				length, _ := r.ReadByte()
+				host := make([]byte, length)
+				_, _ = io.ReadFull(r, host)
+				req.host = string(host)
+			}
+
+			port := make([]byte, 2)
+			_, _ = io.ReadFull(r, port)
+			req.port = binary.BigEndian.Uint16(port)
+
+			_, _ = conn.Write([]byte{Version5, socks5Succeeded, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		}
+
+		requests <- req
+		_, _ = io.Copy(conn, r)
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+func dialAndEcho(t *testing.T, dialer *Dialer, addr string) {
+	conn, err := dialer.DialContext(context.Background(), "tcp", addr)
+	assert.NoError(t, err)
+
+	//nolint:errcheck
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestDialSocks4a(t *testing.T) {
+	addr, requests := serveSocks(t)
+
+	dialAndEcho(t, &Dialer{
+		Version:   Version4,
+		Addr:      addr,
+		Username:  "user",
+		RemoteDNS: true,
+		Forward:   &net.Dialer{},
+	}, "example.org:443")
+
+	assert.Equal(t, request{version: Version4, host: "example.org", port: 443, username: "user"}, <-requests)
+}
+
+func TestDialSocks4(t *testing.T) {
+	addr, requests := serveSocks(t)
+
+	dialAndEcho(t, &Dialer{
+		Version: Version4,
+		Addr:    addr,
+		Forward: &net.Dialer{},
+	}, "localhost:80")
+
+	assert.Equal(t, request{version: Version4, host: "127.0.0.1", port: 80}, <-requests)
+}
+
+func TestDialSocks5(t *testing.T) {
+	addr, requests := serveSocks(t)
+
+	dialAndEcho(t, &Dialer{
+		Version:   Version5,
+		Addr:      addr,
+		RemoteDNS: true,
+		Forward:   &net.Dialer{},
+	}, "example.org:443")
+
+	assert.Equal(t, request{version: Version5, host: "example.org", port: 443}, <-requests)
+}
+
+func TestDialSocks5Auth(t *testing.T) {
+	addr, requests := serveSocks(t)
+
+	dialAndEcho(t, &Dialer{
+		Version:  Version5,
+		Addr:     addr,
+		Username: "user",
+		Password: "secret",
+		Forward:  &net.Dialer{},
+	}, "192.0.2.1:8080")
+
+	assert.Equal(t, request{version: Version5, host: "192.0.2.1", port: 8080, username: "user", password: "secret"}, <-requests)
+}
+
+// serveReply starts a fake socks server, that answers every connection with the reply, if any.
+func serveReply(t *testing.T, reply []byte) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			t.Cleanup(func() { _ = conn.Close() })
+
+			if reply != nil {
+				_, _ = conn.Write(reply)
+			}
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestDialTimeout(t *testing.T) {
+	dialer := &Dialer{
+		Version: Version5,
+		Addr:    serveReply(t, nil),
+		Forward: &net.Dialer{},
+		Timeout: 50 * time.Millisecond,
+	}
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "192.0.2.1:443")
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestDialSocks4InvalidReply(t *testing.T) {
+	dialer := &Dialer{
+		Version: Version4,
+		Addr:    serveReply(t, []byte{Version4, socks4Granted, 0, 0, 0, 0, 0, 0}),
+		Forward: &net.Dialer{},
+	}
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "192.0.2.1:443")
+	assert.EqualError(t, err, "socks4: unexpected reply version 4")
+}